@@ -2,35 +2,18 @@ package auth
 
 import (
 	"context"
-	"crypto/rand"
-	"crypto/sha256"
 	"crypto/tls"
-	"encoding/base64"
-	"encoding/json"
-	"fmt"
+	"errors"
 	"io"
-	"io/ioutil"
+	"math"
 	"net/http"
 	"net/url"
 	"os"
-	"os/exec"
-	"path/filepath"
-	"strings"
-	"sync"
 	"time"
 
-	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/metadata"
 )
 
-const (
-	toposClientID     string = "tJbqmqfttOHJ0kGfy8Bvf60v8Z4pW7T4"
-	toposAudience     string = "https://endpoints.topos.com"
-	toposCallbackPort string = "8676"
-	toposCallback     string = "http://localhost:" + toposCallbackPort + "/callback"
-)
-
 var httpClient *http.Client = &http.Client{
 	Transport: &http.Transport{
 		TLSClientConfig: &tls.Config{
@@ -45,288 +28,330 @@ type getTokenResponse struct {
 	RefreshToken string `json:"refresh_token,omitempty"`
 }
 
-func getToken(ctx context.Context, payload url.Values) (*getTokenResponse, error) {
-	req, err := http.NewRequest("POST", "https://auth.topos.com/oauth/token", strings.NewReader(payload.Encode()))
-	if err != nil {
+func (c *Config) getToken(ctx context.Context, payload url.Values) (*getTokenResponse, error) {
+	t := &getTokenResponse{}
+	if err := postForm(ctx, c.tokenURL(), payload, t); err != nil {
 		return nil, err
 	}
 
-	req = req.WithContext(ctx)
-	req.Header.Add("content-type", "application/x-www-form-urlencoded")
-	response, err := httpClient.Do(req)
-	if err != nil {
-		return nil, err
-	}
+	return t, nil
+}
 
-	if response.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("unexpected status code %d", response.StatusCode)
-	}
+const defaultLoginTimeout = 5 * time.Minute
 
-	t := &getTokenResponse{}
-	if err := json.NewDecoder(response.Body).Decode(t); err != nil {
-		if err := req.Body.Close(); err != nil {
-			return nil, err
-		}
+type loginMode int
 
-		return nil, err
-	}
+const (
+	loginModeAuto loginMode = iota
+	loginModeBrowser
+	loginModeDevice
+)
 
-	return t, nil
+type loginOptions struct {
+	mode        loginMode
+	output      io.Writer
+	openBrowser BrowserOpener
+	config      *Config
+	store       TokenStore
+	profile     string
+	timeout     time.Duration
 }
 
-func randomString() (string, error) {
-	value := make([]byte, 32)
-	if _, err := rand.Read(value); err != nil {
-		return "", err
-	}
+type LoginOption func(*loginOptions)
 
-	return base64.RawURLEncoding.EncodeToString(value), nil
+// LoginWithBrowser forces the browser based PKCE flow.
+func LoginWithBrowser() LoginOption {
+	return func(options *loginOptions) {
+		options.mode = loginModeBrowser
+	}
 }
 
-func sha256SumString(value string) string {
-	sum := sha256.Sum256([]byte(value))
-	return base64.RawURLEncoding.EncodeToString(sum[:])
+// LoginWithDeviceCode forces the device authorization flow, which only
+// requires the user to visit a URL on any device.
+func LoginWithDeviceCode() LoginOption {
+	return func(options *loginOptions) {
+		options.mode = loginModeDevice
+	}
 }
 
-func getTokenPKCE(ctx context.Context) (*getTokenResponse, error) {
-	codeVerifier, err := randomString()
-	if err != nil {
-		return nil, err
+// LoginWithTimeout sets how long to wait for the user to complete an
+// interactive login. Defaults to 5 minutes; zero waits until ctx is done.
+func LoginWithTimeout(timeout time.Duration) LoginOption {
+	return func(options *loginOptions) {
+		options.timeout = timeout
 	}
+}
 
-	state, err := randomString()
-	if err != nil {
-		return nil, err
+// LoginWithOutput sets where login instructions are written. Defaults to
+// os.Stderr.
+func LoginWithOutput(w io.Writer) LoginOption {
+	return func(options *loginOptions) {
+		options.output = w
 	}
+}
 
-	authorizePayload := url.Values{}
-	authorizePayload.Set("audience", toposAudience)
-	authorizePayload.Set("client_id", toposClientID)
-	authorizePayload.Set("code_challenge", sha256SumString(codeVerifier))
-	authorizePayload.Set("code_challenge_method", "S256")
-	authorizePayload.Set("redirect_uri", toposCallback)
-	authorizePayload.Set("response_type", "code")
-	authorizePayload.Set("state", state)
+// LoginWithBrowserOpener sets how the PKCE flow opens the authorize URL and
+// implies LoginWithBrowser.
+func LoginWithBrowserOpener(openBrowser BrowserOpener) LoginOption {
+	return func(options *loginOptions) {
+		options.mode = loginModeBrowser
+		options.openBrowser = openBrowser
+	}
+}
 
-	authorizePayload.Add("scope", "offline_access")
+// LoginWithConfig sets the OAuth environment to log in to. Defaults to the
+// profile's configuration, see LoadProfileConfig.
+func LoginWithConfig(config *Config) LoginOption {
+	return func(options *loginOptions) {
+		options.config = config
+	}
+}
 
-	if err := exec.Command("open", "https://auth.topos.com/authorize?"+authorizePayload.Encode()).Run(); err != nil {
-		return nil, err
+// LoginWithProfile logs in to the named profile, leaving the tokens of other
+// profiles untouched. Defaults to TOPOS_PROFILE or DefaultProfile.
+func LoginWithProfile(profile string) LoginOption {
+	return func(options *loginOptions) {
+		options.profile = profile
 	}
+}
 
-	codeCh := make(chan string)
+// LoginWithTokenStore sets where tokens are saved. Defaults to the config's
+// token store.
+func LoginWithTokenStore(store TokenStore) LoginOption {
+	return func(options *loginOptions) {
+		options.store = store
+	}
+}
 
-	setCode := &sync.Once{}
-	server := &http.Server{
-		Addr: fmt.Sprintf("127.0.0.1:%s", toposCallbackPort),
-		Handler: http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
-			if req.URL.Path != "/callback" {
-				http.Error(w, "not found", http.StatusNotFound)
-				return
-			}
+func (c *Config) getTokenInteractive(ctx context.Context, options *loginOptions) (*getTokenResponse, error) {
+	if options.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, options.timeout)
+		defer cancel()
+	}
 
-			if reqState := req.FormValue("state"); reqState != state {
-				http.Error(w, "unauthorized", http.StatusUnauthorized)
-				return
-			}
+	if options.mode == loginModeDevice {
+		return c.getTokenDevice(ctx, options.output)
+	}
 
-			setCode.Do(func() {
-				codeCh <- req.FormValue("code")
-				fmt.Fprint(w, "ok")
-			})
-		}),
+	if options.openBrowser != nil {
+		return c.getTokenPKCE(ctx, options.openBrowser)
 	}
 
-	errCh := make(chan error)
-	go func() {
-		defer close(codeCh)
-		defer close(errCh)
-		if err := server.ListenAndServe(); err != nil {
-			errCh <- err
+	printOpenBrowser := PrintBrowserOpener(options.output)
+	systemOpenBrowser, err := SystemBrowserOpener()
+	if err != nil {
+		if options.mode == loginModeAuto {
+			return c.getTokenDevice(ctx, options.output)
 		}
-	}()
 
-	code := <-codeCh
-	if err := server.Close(); err != nil {
-		return nil, err
+		return c.getTokenPKCE(ctx, printOpenBrowser)
 	}
 
-	for err := range errCh {
-		if err != http.ErrServerClosed {
-			return nil, err
+	if options.mode == loginModeAuto {
+		// A launcher that fails, for example xdg-open without a working
+		// display, means the callback cannot be reached either.
+		response, err := c.getTokenPKCE(ctx, systemOpenBrowser)
+		if errors.Is(err, ErrNoBrowser) {
+			return c.getTokenDevice(ctx, options.output)
 		}
+
+		return response, err
 	}
 
-	tokenPayload := url.Values{}
-	tokenPayload.Set("client_id", toposClientID)
-	tokenPayload.Set("code", code)
-	tokenPayload.Set("code_verifier", codeVerifier)
-	tokenPayload.Set("grant_type", "authorization_code")
-	tokenPayload.Set("redirect_uri", toposCallback)
+	return c.getTokenPKCE(ctx, func(url string) error {
+		if err := systemOpenBrowser(url); err != nil {
+			return printOpenBrowser(url)
+		}
 
-	return getToken(ctx, tokenPayload)
+		return nil
+	})
 }
 
-func writeString(path, s string) error {
-	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+func Login(ctx context.Context, opts ...LoginOption) error {
+	options := &loginOptions{
+		output:  os.Stderr,
+		timeout: defaultLoginTimeout,
+	}
+
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	config, store, err := loadProfile(options.profile, options.config, options.store)
 	if err != nil {
 		return err
 	}
 
-	if _, err := io.WriteString(file, s); err != nil {
-		if err := file.Close(); err != nil {
+	if _, err := store.Token(refreshTokenKey); err == nil {
+		// Always refresh, even if the stored access token is still valid. A
+		// refresh token that expired or was revoked is replaced by logging in
+		// interactively.
+		_, _, err := config.refreshStoredTokens(ctx, store, math.MaxInt64)
+		if !errors.Is(err, ErrInvalidRefreshToken) {
 			return err
 		}
+	} else if err != ErrTokenNotFound {
+		return err
+	}
+
+	response, err := config.getTokenInteractive(ctx, options)
+	if err != nil {
+		return err
+	}
 
+	unlock, err := lockTokenStore(store)
+	if err != nil {
 		return err
 	}
 
-	return file.Close()
+	defer unlock()
+	return saveTokens(store, response)
+}
+
+func saveTokens(store TokenStore, response *getTokenResponse) error {
+	if response.RefreshToken != "" {
+		if err := store.SetToken(refreshTokenKey, response.RefreshToken); err != nil {
+			return err
+		}
+	}
+
+	return store.SetToken(accessTokenKey, response.AccessToken)
 }
 
-func readString(path string) (string, error) {
-	file, err := os.Open(path)
+// refreshStoredTokens refreshes the tokens kept in store while holding its
+// lock, saving the rotated refresh token if the issuer returns one. If the
+// stored access token is valid until at least validUntil, which happens when
+// another process refreshed it while the lock was being acquired, it is
+// returned instead.
+func (c *Config) refreshStoredTokens(ctx context.Context, store TokenStore, validUntil int64) (string, int64, error) {
+	unlock, err := lockTokenStore(store)
 	if err != nil {
-		return "", err
+		return "", 0, err
 	}
 
-	s, err := ioutil.ReadAll(file)
-	if err := file.Close(); err != nil {
-		return "", err
+	defer unlock()
+	if accessToken, err := store.Token(accessTokenKey); err == nil {
+		if expiry, err := accessTokenExpiry(accessToken); err == nil && expiry >= validUntil {
+			return accessToken, expiry, nil
+		}
+	} else if err != ErrTokenNotFound {
+		return "", 0, err
 	}
 
-	return string(s), err
-}
+	refreshToken, err := store.Token(refreshTokenKey)
+	if err != nil {
+		return "", 0, err
+	}
 
-func Login(ctx context.Context) error {
-	homeDir, err := os.UserHomeDir()
+	now := time.Now().Unix()
+	response, err := c.getTokenRefresh(ctx, refreshToken, TokenScope{})
 	if err != nil {
-		return err
+		return "", 0, err
 	}
 
-	toposDir := filepath.Join(homeDir, ".topos")
-	if err := os.MkdirAll(toposDir, 0700); err != nil {
-		return err
+	if err := saveTokens(store, response); err != nil {
+		return "", 0, err
 	}
 
-	var accessToken string
-	refreshTokenPath := filepath.Join(toposDir, "refresh_token")
-	if refreshToken, err := readString(refreshTokenPath); err != nil {
-		if !os.IsNotExist(err) {
-			return err
-		}
+	return response.AccessToken, now + response.ExpiresIn, nil
+}
 
-		response, err := getTokenPKCE(ctx)
-		if err != nil {
-			return err
-		}
+// refreshScopedToken obtains an access token for scope with the stored
+// refresh token. Only a rotated refresh token is saved, as the stored access
+// token is the one for the default scope.
+func (c *Config) refreshScopedToken(ctx context.Context, store TokenStore, scope TokenScope) (string, int64, error) {
+	unlock, err := lockTokenStore(store)
+	if err != nil {
+		return "", 0, err
+	}
 
-		if err := writeString(refreshTokenPath, response.RefreshToken); err != nil {
-			return err
-		}
+	defer unlock()
+	refreshToken, err := store.Token(refreshTokenKey)
+	if err != nil {
+		return "", 0, err
+	}
 
-		accessToken = response.AccessToken
-	} else {
-		response, err := getTokenRefresh(ctx, refreshToken)
-		if err != nil {
-			return err
-		}
+	now := time.Now().Unix()
+	response, err := c.getTokenRefresh(ctx, refreshToken, scope)
+	if err != nil {
+		return "", 0, err
+	}
 
-		accessToken = response.AccessToken
+	if response.RefreshToken != "" {
+		if err := store.SetToken(refreshTokenKey, response.RefreshToken); err != nil {
+			return "", 0, err
+		}
 	}
 
-	accessTokenPath := filepath.Join(toposDir, "access_token")
-	return writeString(accessTokenPath, accessToken)
+	return response.AccessToken, now + response.ExpiresIn, nil
 }
 
 type localCredentials struct {
-	lock            sync.Mutex
-	refreshToken    string
-	accessTokenPath string
-	accessToken     string
-	expiry          int64
+	config *Config
+	store  TokenStore
+	cache  *tokenCache
+	scoped *scopedTokenCache
 }
 
-type accessTokenExp struct {
-	Exp int64 `json:"exp"`
-}
-
-func newLocalCredentials() (*localCredentials, error) {
-	homeDir, err := os.UserHomeDir()
+func accessTokenExpiry(accessToken string) (int64, error) {
+	claims, err := ParseUnverifiedClaims(accessToken)
 	if err != nil {
-		return nil, err
+		return 0, err
 	}
 
-	toposDir := filepath.Join(homeDir, ".topos")
-	refreshTokenPath := filepath.Join(toposDir, "refresh_token")
-	refreshToken, err := readString(refreshTokenPath)
-	if err != nil {
+	return claims.ExpiresAt, nil
+}
+
+func newLocalCredentials(config *Config, store TokenStore, policy RefreshPolicy, observe RefreshObserver) (*localCredentials, error) {
+	if _, err := store.Token(refreshTokenKey); err != nil {
 		return nil, err
 	}
 
-	accessTokenPath := filepath.Join(toposDir, "access_token")
-	accessToken, err := readString(accessTokenPath)
+	accessToken, err := store.Token(accessTokenKey)
 	if err != nil {
 		return nil, err
 	}
 
-	accessTokenComponents := strings.SplitN(accessToken, ".", 3)
-	if len(accessTokenComponents) != 3 {
-		return nil, fmt.Errorf("invalid access_token")
-	}
-
-	accessTokenPayloadJSON, err := base64.RawURLEncoding.DecodeString(accessTokenComponents[1])
+	expiry, err := accessTokenExpiry(accessToken)
 	if err != nil {
 		return nil, err
 	}
 
-	exp := accessTokenExp{}
-	if err := json.Unmarshal(accessTokenPayloadJSON, &exp); err != nil {
-		return nil, err
+	c := &localCredentials{
+		config: config,
+		store:  store,
 	}
 
-	return &localCredentials{
-		refreshToken:    refreshToken,
-		accessTokenPath: accessTokenPath,
-		accessToken:     accessToken,
-		expiry:          exp.Exp,
-	}, nil
+	c.cache = newTokenCache(policy, observe, c.refresh)
+	c.cache.set(accessToken, expiry)
+	return c, nil
 }
 
-func getTokenRefresh(ctx context.Context, refreshToken string) (*getTokenResponse, error) {
+func (c *Config) getTokenRefresh(ctx context.Context, refreshToken string, scope TokenScope) (*getTokenResponse, error) {
 	payload := url.Values{}
 	payload.Add("grant_type", "refresh_token")
-	payload.Add("client_id", toposClientID)
+	payload.Add("client_id", c.ClientID)
 	payload.Add("refresh_token", refreshToken)
+	scope.setPayload(payload)
 
-	return getToken(ctx, payload)
+	return c.getToken(ctx, payload)
 }
 
-func (c *localCredentials) authorization(ctx context.Context) (string, error) {
-	c.lock.Lock()
-	defer c.lock.Unlock()
-
-	now := time.Now().Unix()
-	if now < c.expiry {
-		return c.accessToken, nil
-	}
-
-	response, err := getTokenRefresh(ctx, c.refreshToken)
-	if err != nil {
-		return "", err
-	}
+func (c *localCredentials) refresh(ctx context.Context, validUntil int64) (string, int64, error) {
+	return c.config.refreshStoredTokens(ctx, c.store, validUntil)
+}
 
-	if err := writeString(c.accessTokenPath, response.AccessToken); err != nil {
-		return "", err
-	}
+func (c *localCredentials) refreshScoped(ctx context.Context, scope TokenScope) (string, int64, error) {
+	return c.config.refreshScopedToken(ctx, c.store, scope)
+}
 
-	c.accessToken = response.AccessToken
-	c.expiry = now + response.ExpiresIn
-	return c.accessToken, nil
+func (c *localCredentials) setScopeResolver(resolve ScopeResolver) {
+	c.scoped = newScopedTokenCache(c.cache.policy, c.cache.observe, resolve, c.refreshScoped)
 }
 
 func (c *localCredentials) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
-	accessToken, err := c.authorization(ctx)
+	accessToken, err := c.scoped.token(ctx, c.cache, uri)
 	if err != nil {
 		return nil, err
 	}
@@ -336,6 +361,10 @@ func (c *localCredentials) GetRequestMetadata(ctx context.Context, uri ...string
 	}, nil
 }
 
+func (c *localCredentials) invalidate(accessToken string) {
+	c.scoped.invalidate(c.cache, accessToken)
+}
+
 func (*localCredentials) RequireTransportSecurity() bool {
 	return true
 }
@@ -363,27 +392,3 @@ func (remoteCredentials) GetRequestMetadata(ctx context.Context, uri ...string)
 func (remoteCredentials) RequireTransportSecurity() bool {
 	return true
 }
-
-func withAddr(addr string) grpc.DialOption {
-	if strings.HasPrefix(addr, "localhost:") || strings.HasPrefix(addr, "127.0.0.1:") {
-		return grpc.WithInsecure()
-	}
-
-	return grpc.WithTransportCredentials(credentials.NewTLS(&tls.Config{
-		MinVersion:         tls.VersionTLS12,
-		InsecureSkipVerify: !strings.Contains(addr, "."),
-	}))
-}
-
-func Dial(addr string, useLocalCredentials bool) (*grpc.ClientConn, error) {
-	if !useLocalCredentials {
-		return grpc.Dial(addr, withAddr(addr), grpc.WithPerRPCCredentials(remoteCredentials{}))
-	}
-
-	creds, err := newLocalCredentials()
-	if err != nil {
-		return nil, err
-	}
-
-	return grpc.Dial(addr, withAddr(addr), grpc.WithPerRPCCredentials(creds))
-}
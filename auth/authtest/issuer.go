@@ -0,0 +1,730 @@
+package authtest
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/topos-ai/topos-apis-go/auth"
+)
+
+// An Endpoint is the path of an OAuth endpoint served by an Issuer.
+type Endpoint string
+
+const (
+	EndpointAuthorize  Endpoint = "/authorize"
+	EndpointToken      Endpoint = "/oauth/token"
+	EndpointRevocation Endpoint = "/oauth/revoke"
+	EndpointJWKS       Endpoint = "/.well-known/jwks.json"
+)
+
+const (
+	// DefaultClientID is the public client used by the Issuer's Config.
+	DefaultClientID = "authtest-client"
+
+	// DefaultAudience is the audience of the Issuer's Config.
+	DefaultAudience = "https://endpoints.topos.test"
+
+	// DefaultSubject is the user every authorization request is granted to.
+	DefaultSubject = "authtest|user"
+
+	DefaultAccessTokenLifetime = time.Hour
+
+	tokenExchangeGrantType = "urn:ietf:params:oauth:grant-type:token-exchange"
+	accessTokenType        = "urn:ietf:params:oauth:token-type:access_token"
+)
+
+// A Failure is an error response an Issuer returns instead of handling a
+// request. The authorize endpoint reports it by redirecting to the client
+// with the error parameters, like a real issuer. StatusCode defaults to 400,
+// or to 500 for server_error and 503 for temporarily_unavailable.
+type Failure struct {
+	StatusCode  int
+	Code        string
+	Description string
+	RetryAfter  time.Duration
+}
+
+type grant struct {
+	subject  string
+	clientID string
+	audience string
+	scope    string
+}
+
+type authorizationCode struct {
+	grant
+	redirectURI   string
+	codeChallenge string
+}
+
+type refreshToken struct {
+	grant
+	expiresAt time.Time
+}
+
+// Issuer is an in-process OAuth issuer implementing the endpoints the auth
+// package uses: authorize with PKCE, token with the authorization_code,
+// refresh_token, client_credentials and token exchange grants, revocation and
+// JWKS. Authorization requests are granted to the configured subject without
+// user interaction. Access tokens are RS256 signed JWTs.
+type Issuer struct {
+	// URL is the base URL of the issuer, of the form http://127.0.0.1:port.
+	URL string
+
+	// Config points the auth package at the issuer.
+	Config *auth.Config
+
+	server *httptest.Server
+	key    *rsa.PrivateKey
+	keyID  string
+
+	lock                 sync.Mutex
+	subject              string
+	scopes               []string
+	claims               map[string]interface{}
+	accessTokenLifetime  time.Duration
+	refreshTokenLifetime time.Duration
+	rotateRefreshTokens  bool
+	clients              map[string]string
+	codes                map[string]*authorizationCode
+	refreshTokens        map[string]*refreshToken
+	failures             map[Endpoint][]Failure
+	requests             map[Endpoint]int
+}
+
+type IssuerOption func(*Issuer)
+
+// WithSubject sets the sub claim of tokens issued to users. Defaults to
+// DefaultSubject.
+func WithSubject(subject string) IssuerOption {
+	return func(issuer *Issuer) {
+		issuer.subject = subject
+	}
+}
+
+// WithScopes sets the scope claim of issued access tokens.
+func WithScopes(scopes ...string) IssuerOption {
+	return func(issuer *Issuer) {
+		issuer.scopes = scopes
+	}
+}
+
+// WithClaims adds claims to every issued access token, overriding the
+// standard ones of the same name.
+func WithClaims(claims map[string]interface{}) IssuerOption {
+	return func(issuer *Issuer) {
+		issuer.claims = claims
+	}
+}
+
+// WithAccessTokenLifetime sets how long issued access tokens are valid.
+// Defaults to DefaultAccessTokenLifetime.
+func WithAccessTokenLifetime(lifetime time.Duration) IssuerOption {
+	return func(issuer *Issuer) {
+		issuer.accessTokenLifetime = lifetime
+	}
+}
+
+// WithRefreshTokenLifetime sets how long refresh tokens are valid. Defaults
+// to forever.
+func WithRefreshTokenLifetime(lifetime time.Duration) IssuerOption {
+	return func(issuer *Issuer) {
+		issuer.refreshTokenLifetime = lifetime
+	}
+}
+
+// WithRefreshTokenRotation issues a new refresh token on every refresh and
+// invalidates the old one.
+func WithRefreshTokenRotation() IssuerOption {
+	return func(issuer *Issuer) {
+		issuer.rotateRefreshTokens = true
+	}
+}
+
+// WithClient registers a confidential client, for example a service account
+// using the client credentials grant.
+func WithClient(clientID, clientSecret string) IssuerOption {
+	return func(issuer *Issuer) {
+		issuer.clients[clientID] = clientSecret
+	}
+}
+
+func randomString() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(fmt.Sprintf("authtest: %v", err))
+	}
+
+	return hex.EncodeToString(b)
+}
+
+// NewIssuer starts an Issuer. The caller should call Close when finished.
+func NewIssuer(opts ...IssuerOption) *Issuer {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		panic(fmt.Sprintf("authtest: failed to generate signing key: %v", err))
+	}
+
+	issuer := &Issuer{
+		key:                 key,
+		keyID:               randomString(),
+		subject:             DefaultSubject,
+		accessTokenLifetime: DefaultAccessTokenLifetime,
+		clients: map[string]string{
+			DefaultClientID: "",
+		},
+		codes:         map[string]*authorizationCode{},
+		refreshTokens: map[string]*refreshToken{},
+		failures:      map[Endpoint][]Failure{},
+		requests:      map[Endpoint]int{},
+	}
+
+	for _, opt := range opts {
+		opt(issuer)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(string(EndpointAuthorize), issuer.handle(EndpointAuthorize, issuer.authorize))
+	mux.HandleFunc(string(EndpointToken), issuer.handle(EndpointToken, issuer.token))
+	mux.HandleFunc(string(EndpointRevocation), issuer.handle(EndpointRevocation, issuer.revoke))
+	mux.HandleFunc(string(EndpointJWKS), issuer.handle(EndpointJWKS, issuer.jwks))
+
+	issuer.server = httptest.NewServer(mux)
+	issuer.URL = issuer.server.URL
+	issuer.Config = &auth.Config{
+		Issuer:   issuer.URL + "/",
+		ClientID: DefaultClientID,
+		Audience: DefaultAudience,
+	}
+
+	return issuer
+}
+
+// Close shuts down the issuer.
+func (i *Issuer) Close() {
+	i.server.Close()
+}
+
+// InjectFailure makes the next count requests to endpoint fail with failure.
+func (i *Issuer) InjectFailure(endpoint Endpoint, failure Failure, count int) {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	for ; count > 0; count-- {
+		i.failures[endpoint] = append(i.failures[endpoint], failure)
+	}
+}
+
+// Requests returns how many requests endpoint received, including failed
+// ones.
+func (i *Issuer) Requests(endpoint Endpoint) int {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	return i.requests[endpoint]
+}
+
+// SetAccessTokenLifetime changes the lifetime of access tokens issued from
+// now on.
+func (i *Issuer) SetAccessTokenLifetime(lifetime time.Duration) {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	i.accessTokenLifetime = lifetime
+}
+
+// RevokeAll invalidates every refresh token issued so far.
+func (i *Issuer) RevokeAll() {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	i.refreshTokens = map[string]*refreshToken{}
+}
+
+// ServiceAccount registers a new confidential client and returns it as a
+// service account.
+func (i *Issuer) ServiceAccount() *auth.ServiceAccount {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	account := &auth.ServiceAccount{
+		ClientID:     "authtest-" + randomString(),
+		ClientSecret: randomString(),
+	}
+
+	i.clients[account.ClientID] = account.ClientSecret
+	return account
+}
+
+// OpenBrowser completes an authorization request in place of the user's
+// browser, following the redirect to the client's callback. Pass it to
+// auth.LoginWithBrowserOpener.
+func (i *Issuer) OpenBrowser(authorizeURL string) error {
+	response, err := http.Get(authorizeURL)
+	if err != nil {
+		return err
+	}
+
+	return response.Body.Close()
+}
+
+type keySet struct {
+	keyID string
+	key   crypto.PublicKey
+}
+
+func (s *keySet) Key(ctx context.Context, keyID string) (crypto.PublicKey, error) {
+	if keyID != s.keyID {
+		return nil, fmt.Errorf("unknown signing key %q", keyID)
+	}
+
+	return s.key, nil
+}
+
+// KeySet returns the issuer's signing keys without fetching them over HTTP.
+func (i *Issuer) KeySet() auth.KeySet {
+	return &keySet{
+		keyID: i.keyID,
+		key:   i.key.Public(),
+	}
+}
+
+// Verifier returns a Verifier accepting the access tokens of the issuer.
+func (i *Issuer) Verifier(requiredScopes ...string) *auth.Verifier {
+	verifier := auth.NewVerifier(i.Config, requiredScopes...)
+	verifier.Keys = i.KeySet()
+	return verifier
+}
+
+func encodeSegment(v interface{}) string {
+	b, err := json.Marshal(v)
+	if err != nil {
+		panic(fmt.Sprintf("authtest: %v", err))
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// Sign returns a JWT carrying claims signed by the issuer, for example to
+// test the handling of expired or foreign tokens.
+func (i *Issuer) Sign(claims map[string]interface{}) string {
+	signed := encodeSegment(map[string]string{
+		"alg": "RS256",
+		"typ": "JWT",
+		"kid": i.keyID,
+	}) + "." + encodeSegment(claims)
+
+	digest := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, i.key, crypto.SHA256, digest[:])
+	if err != nil {
+		panic(fmt.Sprintf("authtest: %v", err))
+	}
+
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+// AccessToken returns an access token for subject as the token endpoint
+// would issue it, with claims added.
+func (i *Issuer) AccessToken(subject string, claims map[string]interface{}) string {
+	i.lock.Lock()
+	accessToken, _ := i.accessToken(grant{
+		subject:  subject,
+		clientID: DefaultClientID,
+		audience: i.Config.Audience,
+		scope:    i.grantedScope(""),
+	})
+	i.lock.Unlock()
+
+	if len(claims) == 0 {
+		return accessToken
+	}
+
+	parsed, _ := auth.ParseUnverifiedClaims(accessToken)
+	for name, value := range claims {
+		parsed.Raw[name] = value
+	}
+
+	return i.Sign(parsed.Raw)
+}
+
+// grantedScope returns the requested scope together with the scopes set with
+// WithScopes. It must be called with i.lock held.
+func (i *Issuer) grantedScope(requested string) string {
+	scopes := strings.Fields(requested)
+	for _, scope := range i.scopes {
+		if !hasScope(requested, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+
+	return strings.Join(scopes, " ")
+}
+
+// requestedScope returns scope if a client narrowed the scope of its token,
+// or the scopes set with WithScopes. It must be called with i.lock held.
+func (i *Issuer) requestedScope(scope string) string {
+	if scope != "" {
+		return scope
+	}
+
+	return i.grantedScope("")
+}
+
+// accessToken must be called with i.lock held.
+func (i *Issuer) accessToken(g grant) (string, time.Duration) {
+	now := time.Now()
+	claims := map[string]interface{}{
+		"iss": i.Config.Issuer,
+		"sub": g.subject,
+		"aud": []string{g.audience},
+		"azp": g.clientID,
+		"iat": now.Unix(),
+		"exp": now.Add(i.accessTokenLifetime).Unix(),
+	}
+
+	if g.scope != "" {
+		claims["scope"] = g.scope
+	}
+
+	for name, value := range i.claims {
+		claims[name] = value
+	}
+
+	return i.Sign(claims), i.accessTokenLifetime
+}
+
+func writeJSON(w http.ResponseWriter, statusCode int, v interface{}) {
+	w.Header().Set("content-type", "application/json")
+	w.Header().Set("cache-control", "no-store")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, failure Failure) {
+	if failure.RetryAfter > 0 {
+		w.Header().Set("retry-after", strconv.Itoa(int(failure.RetryAfter/time.Second)))
+	}
+
+	statusCode := failure.StatusCode
+	if statusCode == 0 {
+		switch failure.Code {
+		case "server_error":
+			statusCode = http.StatusInternalServerError
+		case "temporarily_unavailable":
+			statusCode = http.StatusServiceUnavailable
+		default:
+			statusCode = http.StatusBadRequest
+		}
+	}
+
+	response := map[string]string{
+		"error": failure.Code,
+	}
+
+	if failure.Description != "" {
+		response["error_description"] = failure.Description
+	}
+
+	writeJSON(w, statusCode, response)
+}
+
+func badRequest(code, description string) *Failure {
+	return &Failure{
+		StatusCode:  http.StatusBadRequest,
+		Code:        code,
+		Description: description,
+	}
+}
+
+type handler func(w http.ResponseWriter, req *http.Request, failure *Failure) *Failure
+
+// handle counts requests to endpoint and pops an injected failure, if any,
+// for h to report.
+func (i *Issuer) handle(endpoint Endpoint, h handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		i.lock.Lock()
+		i.requests[endpoint]++
+		var failure *Failure
+		if failures := i.failures[endpoint]; len(failures) > 0 {
+			failure = &failures[0]
+			i.failures[endpoint] = failures[1:]
+		}
+		i.lock.Unlock()
+
+		if err := h(w, req, failure); err != nil {
+			writeError(w, *err)
+		}
+	}
+}
+
+func redirect(w http.ResponseWriter, req *http.Request, redirectURI string, params url.Values) {
+	http.Redirect(w, req, redirectURI+"?"+params.Encode(), http.StatusFound)
+}
+
+func (i *Issuer) authorize(w http.ResponseWriter, req *http.Request, failure *Failure) *Failure {
+	query := req.URL.Query()
+	redirectURI := query.Get("redirect_uri")
+	if redirectURI == "" {
+		return badRequest("invalid_request", "missing redirect_uri")
+	}
+
+	i.lock.Lock()
+	_, ok := i.clients[query.Get("client_id")]
+	i.lock.Unlock()
+	if !ok {
+		return badRequest("invalid_client", "unknown client_id")
+	}
+
+	params := url.Values{}
+	params.Set("state", query.Get("state"))
+	switch {
+	case failure != nil:
+		params.Set("error", failure.Code)
+		params.Set("error_description", failure.Description)
+	case query.Get("response_type") != "code":
+		params.Set("error", "unsupported_response_type")
+	case query.Get("code_challenge") == "" || query.Get("code_challenge_method") != "S256":
+		params.Set("error", "invalid_request")
+		params.Set("error_description", "PKCE with S256 is required")
+	default:
+		code := randomString()
+		i.lock.Lock()
+		i.codes[code] = &authorizationCode{
+			grant: grant{
+				subject:  i.subject,
+				clientID: query.Get("client_id"),
+				audience: query.Get("audience"),
+				scope:    i.grantedScope(query.Get("scope")),
+			},
+			redirectURI:   redirectURI,
+			codeChallenge: query.Get("code_challenge"),
+		}
+		i.lock.Unlock()
+
+		params.Set("code", code)
+	}
+
+	redirect(w, req, redirectURI, params)
+	return nil
+}
+
+// authenticateClient must be called with i.lock held.
+func (i *Issuer) authenticateClient(req *http.Request) (string, *Failure) {
+	clientID := req.PostForm.Get("client_id")
+	secret, ok := i.clients[clientID]
+	if !ok || req.PostForm.Get("client_secret") != secret {
+		return "", &Failure{
+			StatusCode:  http.StatusUnauthorized,
+			Code:        "invalid_client",
+			Description: "client authentication failed",
+		}
+	}
+
+	return clientID, nil
+}
+
+func hasScope(scope, want string) bool {
+	for _, s := range strings.Fields(scope) {
+		if s == want {
+			return true
+		}
+	}
+
+	return false
+}
+
+// newRefreshToken must be called with i.lock held.
+func (i *Issuer) newRefreshToken(g grant) string {
+	token := &refreshToken{
+		grant: g,
+	}
+
+	if i.refreshTokenLifetime > 0 {
+		token.expiresAt = time.Now().Add(i.refreshTokenLifetime)
+	}
+
+	value := randomString()
+	i.refreshTokens[value] = token
+	return value
+}
+
+// issue must be called with i.lock held.
+func (i *Issuer) issue(g grant, offline bool) map[string]interface{} {
+	if g.audience == "" {
+		g.audience = i.Config.Audience
+	}
+
+	accessToken, lifetime := i.accessToken(g)
+	response := map[string]interface{}{
+		"access_token":      accessToken,
+		"token_type":        "Bearer",
+		"expires_in":        int64(lifetime / time.Second),
+		"issued_token_type": accessTokenType,
+	}
+
+	if offline {
+		response["refresh_token"] = i.newRefreshToken(g)
+	}
+
+	return response
+}
+
+func (i *Issuer) token(w http.ResponseWriter, req *http.Request, failure *Failure) *Failure {
+	if failure != nil {
+		return failure
+	}
+
+	if req.Method != "POST" {
+		return &Failure{StatusCode: http.StatusMethodNotAllowed, Code: "invalid_request"}
+	}
+
+	if err := req.ParseForm(); err != nil {
+		return badRequest("invalid_request", err.Error())
+	}
+
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	clientID, failure := i.authenticateClient(req)
+	if failure != nil {
+		return failure
+	}
+
+	form := req.PostForm
+	switch form.Get("grant_type") {
+	case "authorization_code":
+		code, ok := i.codes[form.Get("code")]
+		delete(i.codes, form.Get("code"))
+		if !ok || code.clientID != clientID || code.redirectURI != form.Get("redirect_uri") {
+			return badRequest("invalid_grant", "invalid authorization code")
+		}
+
+		challenge := sha256.Sum256([]byte(form.Get("code_verifier")))
+		if base64.RawURLEncoding.EncodeToString(challenge[:]) != code.codeChallenge {
+			return badRequest("invalid_grant", "code_verifier does not match code_challenge")
+		}
+
+		writeJSON(w, http.StatusOK, i.issue(code.grant, hasScope(code.scope, "offline_access")))
+	case "refresh_token":
+		token, ok := i.refreshTokens[form.Get("refresh_token")]
+		if !ok || token.clientID != clientID || (!token.expiresAt.IsZero() && time.Now().After(token.expiresAt)) {
+			return badRequest("invalid_grant", "invalid refresh token")
+		}
+
+		// The audience and scope may be changed for the new access token
+		// only, the refresh token keeps its original grant.
+		g := token.grant
+		if audience := form.Get("audience"); audience != "" {
+			g.audience = audience
+		}
+
+		if scope := form.Get("scope"); scope != "" {
+			g.scope = scope
+		}
+
+		response := i.issue(g, false)
+		if i.rotateRefreshTokens {
+			delete(i.refreshTokens, form.Get("refresh_token"))
+			response["refresh_token"] = i.newRefreshToken(token.grant)
+		}
+
+		writeJSON(w, http.StatusOK, response)
+	case "client_credentials":
+		if i.clients[clientID] == "" {
+			return &Failure{StatusCode: http.StatusUnauthorized, Code: "unauthorized_client", Description: "public clients cannot use client_credentials"}
+		}
+
+		writeJSON(w, http.StatusOK, i.issue(grant{
+			subject:  clientID + "@clients",
+			clientID: clientID,
+			audience: form.Get("audience"),
+			scope:    i.requestedScope(form.Get("scope")),
+		}, false))
+	case tokenExchangeGrantType:
+		if form.Get("subject_token_type") != accessTokenType {
+			return badRequest("invalid_request", "unsupported subject_token_type")
+		}
+
+		verifier := &auth.Verifier{
+			Keys:   i.KeySet(),
+			Issuer: i.Config.Issuer,
+		}
+
+		claims, err := verifier.Verify(req.Context(), form.Get("subject_token"))
+		if err != nil {
+			return badRequest("invalid_grant", err.Error())
+		}
+
+		scope := form.Get("scope")
+		if scope == "" {
+			scope = claims.Scope
+		}
+
+		writeJSON(w, http.StatusOK, i.issue(grant{
+			subject:  claims.Subject,
+			clientID: clientID,
+			audience: form.Get("audience"),
+			scope:    scope,
+		}, false))
+	default:
+		return badRequest("unsupported_grant_type", "")
+	}
+
+	return nil
+}
+
+func (i *Issuer) revoke(w http.ResponseWriter, req *http.Request, failure *Failure) *Failure {
+	if failure != nil {
+		return failure
+	}
+
+	if err := req.ParseForm(); err != nil {
+		return badRequest("invalid_request", err.Error())
+	}
+
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	if _, failure := i.authenticateClient(req); failure != nil {
+		return failure
+	}
+
+	// Unknown tokens are not an error, see RFC 7009.
+	delete(i.refreshTokens, req.PostForm.Get("token"))
+	w.WriteHeader(http.StatusOK)
+	return nil
+}
+
+func (i *Issuer) jwks(w http.ResponseWriter, req *http.Request, failure *Failure) *Failure {
+	if failure != nil {
+		return failure
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": i.keyID,
+			"use": "sig",
+			"alg": "RS256",
+			"n":   base64.RawURLEncoding.EncodeToString(i.key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(i.key.E)).Bytes()),
+		}},
+	})
+
+	return nil
+}
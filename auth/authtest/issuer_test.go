@@ -0,0 +1,189 @@
+package authtest_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/topos-ai/topos-apis-go/auth"
+	"github.com/topos-ai/topos-apis-go/auth/authtest"
+)
+
+func login(issuer *authtest.Issuer, store auth.TokenStore) error {
+	return auth.Login(context.Background(),
+		auth.LoginWithConfig(issuer.Config),
+		auth.LoginWithTokenStore(store),
+		auth.LoginWithBrowser(),
+		auth.LoginWithBrowserOpener(issuer.OpenBrowser),
+	)
+}
+
+func accessToken(t *testing.T, opts ...auth.DialOption) string {
+	t.Helper()
+
+	source, err := auth.TokenSource(true, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := source.Token()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return token.AccessToken
+}
+
+func TestLogin(t *testing.T) {
+	issuer := authtest.NewIssuer(authtest.WithScopes("read:points"))
+	defer issuer.Close()
+
+	store := auth.NewMemoryTokenStore()
+	if err := login(issuer, store); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := issuer.Requests(authtest.EndpointAuthorize); n != 1 {
+		t.Fatalf("authorize requests = %d, want 1", n)
+	}
+
+	token := accessToken(t, auth.DialWithConfig(issuer.Config), auth.DialWithTokenStore(store))
+	claims, err := issuer.Verifier("read:points").Verify(context.Background(), token)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if claims.Subject != authtest.DefaultSubject {
+		t.Errorf("subject = %q, want %q", claims.Subject, authtest.DefaultSubject)
+	}
+}
+
+func TestLoginRefreshRotation(t *testing.T) {
+	issuer := authtest.NewIssuer(authtest.WithRefreshTokenRotation())
+	defer issuer.Close()
+
+	store := auth.NewMemoryTokenStore()
+	if err := login(issuer, store); err != nil {
+		t.Fatal(err)
+	}
+
+	refreshToken, err := store.Token("refresh_token")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Logging in again refreshes the stored tokens.
+	for i := 0; i < 2; i++ {
+		if err := login(issuer, store); err != nil {
+			t.Fatal(err)
+		}
+
+		rotated, err := store.Token("refresh_token")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if rotated == refreshToken {
+			t.Fatal("refresh token was not rotated")
+		}
+
+		refreshToken = rotated
+	}
+
+	if n := issuer.Requests(authtest.EndpointAuthorize); n != 1 {
+		t.Fatalf("authorize requests = %d, want 1", n)
+	}
+
+	// A revoked refresh token is replaced by logging in interactively.
+	issuer.RevokeAll()
+	if err := login(issuer, store); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := issuer.Requests(authtest.EndpointAuthorize); n != 2 {
+		t.Fatalf("authorize requests = %d, want 2", n)
+	}
+}
+
+func TestClientCredentials(t *testing.T) {
+	issuer := authtest.NewIssuer()
+	defer issuer.Close()
+
+	account := issuer.ServiceAccount()
+	token := accessToken(t, auth.DialWithConfig(issuer.Config), auth.DialWithServiceAccount(account))
+	claims, err := issuer.Verifier().Verify(context.Background(), token)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := account.ClientID + "@clients"; claims.Subject != want {
+		t.Errorf("subject = %q, want %q", claims.Subject, want)
+	}
+}
+
+func TestClientCredentialsRetry(t *testing.T) {
+	issuer := authtest.NewIssuer()
+	defer issuer.Close()
+
+	issuer.InjectFailure(authtest.EndpointToken, authtest.Failure{Code: "server_error"}, 1)
+	source, err := auth.TokenSource(true, auth.DialWithConfig(issuer.Config), auth.DialWithServiceAccount(issuer.ServiceAccount()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := source.Token(); err != nil {
+		t.Fatal(err)
+	}
+
+	if requests := issuer.Requests(authtest.EndpointToken); requests != 2 {
+		t.Errorf("requests = %d, want 2", requests)
+	}
+}
+
+func TestClientCredentialsFailure(t *testing.T) {
+	issuer := authtest.NewIssuer()
+	defer issuer.Close()
+
+	issuer.InjectFailure(authtest.EndpointToken, authtest.Failure{Code: "server_error"}, 3)
+	source, err := auth.TokenSource(true, auth.DialWithConfig(issuer.Config), auth.DialWithServiceAccount(issuer.ServiceAccount()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := source.Token(); !errors.Is(err, auth.ErrServerError) {
+		t.Fatalf("err = %v, want %v", err, auth.ErrServerError)
+	}
+}
+
+func TestVerifierJWKS(t *testing.T) {
+	issuer := authtest.NewIssuer()
+	defer issuer.Close()
+
+	verifier := auth.NewVerifier(issuer.Config)
+	ctx := context.Background()
+	for i := 0; i < 3; i++ {
+		if _, err := verifier.Verify(ctx, issuer.AccessToken("alice", nil)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if n := issuer.Requests(authtest.EndpointJWKS); n != 1 {
+		t.Errorf("JWKS requests = %d, want 1", n)
+	}
+
+	expired := issuer.AccessToken("alice", map[string]interface{}{
+		"exp": time.Now().Add(-time.Hour).Unix(),
+	})
+
+	if _, err := verifier.Verify(ctx, expired); err == nil {
+		t.Error("expired token verified")
+	}
+
+	foreign := authtest.NewIssuer()
+	defer foreign.Close()
+
+	if _, err := verifier.Verify(ctx, foreign.AccessToken("alice", nil)); err == nil {
+		t.Error("token of another issuer verified")
+	}
+}
@@ -0,0 +1,182 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// browserStartTimeout is how long a launcher may take to fail before the
+// browser is assumed to be open. Launchers such as sensible-browser run the
+// browser in the foreground, so they are not waited for any longer.
+const browserStartTimeout = 2 * time.Second
+
+// ErrNoBrowser is returned when no browser could be launched.
+var ErrNoBrowser = errors.New("no browser available")
+
+// A BrowserOpener opens the authorize URL of the PKCE login flow. Tests can
+// supply their own BrowserOpener to capture the URL and complete the callback
+// programmatically.
+type BrowserOpener func(url string) error
+
+// CommandBrowserOpener runs the named command with the URL appended to args.
+// The opener fails if the command exits with an error within a short delay;
+// a command still running after it is reaped in the background.
+func CommandBrowserOpener(name string, args ...string) BrowserOpener {
+	return func(url string) error {
+		return startBrowser(exec.Command(name, append(args, url)...))
+	}
+}
+
+func startBrowser(cmd *exec.Cmd) error {
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	exited := make(chan error, 1)
+	go func() {
+		exited <- cmd.Wait()
+	}()
+
+	timer := time.NewTimer(browserStartTimeout)
+	defer timer.Stop()
+
+	select {
+	case err := <-exited:
+		return err
+	case <-timer.C:
+		return nil
+	}
+}
+
+// PrintBrowserOpener writes the URL to w so the user can open it manually.
+func PrintBrowserOpener(w io.Writer) BrowserOpener {
+	return func(url string) error {
+		_, err := fmt.Fprintf(w, "To log in, open the following URL in a browser:\n\n    %s\n\n", url)
+		return err
+	}
+}
+
+func firstBrowserOpener(openers ...BrowserOpener) BrowserOpener {
+	return func(url string) error {
+		for _, opener := range openers {
+			if err := opener(url); err == nil {
+				return nil
+			}
+		}
+
+		return ErrNoBrowser
+	}
+}
+
+func envBrowserOpeners() []BrowserOpener {
+	var openers []BrowserOpener
+	for _, browser := range strings.Split(os.Getenv("BROWSER"), string(os.PathListSeparator)) {
+		fields := strings.Fields(browser)
+		if len(fields) == 0 {
+			continue
+		}
+
+		if _, err := exec.LookPath(fields[0]); err != nil {
+			continue
+		}
+
+		openers = append(openers, func(url string) error {
+			args := make([]string, 0, len(fields))
+			substituted := false
+			for _, field := range fields[1:] {
+				if strings.Contains(field, "%s") {
+					field = strings.Replace(field, "%s", url, -1)
+					substituted = true
+				}
+
+				args = append(args, field)
+			}
+
+			if !substituted {
+				args = append(args, url)
+			}
+
+			return startBrowser(exec.Command(fields[0], args...))
+		})
+	}
+
+	return openers
+}
+
+func lookPathBrowserOpeners(commands ...[]string) []BrowserOpener {
+	var openers []BrowserOpener
+	for _, command := range commands {
+		if _, err := exec.LookPath(command[0]); err != nil {
+			continue
+		}
+
+		openers = append(openers, CommandBrowserOpener(command[0], command[1:]...))
+	}
+
+	return openers
+}
+
+func isWSL() bool {
+	if os.Getenv("WSL_DISTRO_NAME") != "" {
+		return true
+	}
+
+	release, err := ioutil.ReadFile("/proc/sys/kernel/osrelease")
+	if err != nil {
+		return false
+	}
+
+	return strings.Contains(strings.ToLower(string(release)), "microsoft")
+}
+
+func hasDisplay() bool {
+	return os.Getenv("DISPLAY") != "" || os.Getenv("WAYLAND_DISPLAY") != ""
+}
+
+func systemBrowserOpeners() []BrowserOpener {
+	openers := envBrowserOpeners()
+	switch runtime.GOOS {
+	case "darwin":
+		openers = append(openers, lookPathBrowserOpeners([]string{"open"})...)
+	case "windows":
+		openers = append(openers, lookPathBrowserOpeners([]string{"rundll32", "url.dll,FileProtocolHandler"})...)
+	case "linux":
+		if isWSL() {
+			openers = append(openers, lookPathBrowserOpeners(
+				[]string{"wslview"},
+				[]string{"rundll32.exe", "url.dll,FileProtocolHandler"},
+			)...)
+		}
+
+		if hasDisplay() {
+			openers = append(openers, lookPathBrowserOpeners(
+				[]string{"xdg-open"},
+				[]string{"sensible-browser"},
+			)...)
+		}
+	default:
+		if hasDisplay() {
+			openers = append(openers, lookPathBrowserOpeners([]string{"xdg-open"})...)
+		}
+	}
+
+	return openers
+}
+
+// SystemBrowserOpener returns a BrowserOpener that tries $BROWSER and the
+// platform's launchers in turn. It returns ErrNoBrowser if none is available.
+func SystemBrowserOpener() (BrowserOpener, error) {
+	openers := systemBrowserOpeners()
+	if len(openers) == 0 {
+		return nil, ErrNoBrowser
+	}
+
+	return firstBrowserOpener(openers...), nil
+}
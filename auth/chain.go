@@ -0,0 +1,135 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"google.golang.org/grpc/credentials"
+)
+
+// A CredentialSource names where FindDefaultCredentials found credentials.
+type CredentialSource string
+
+const (
+	// SourceEnvironment is an access token in TOPOS_ACCESS_TOKEN.
+	SourceEnvironment CredentialSource = "environment"
+
+	// SourceServiceAccount is a service account, see LoadServiceAccount.
+	SourceServiceAccount CredentialSource = "service-account"
+
+	// SourceProfile is the local profile written by Login.
+	SourceProfile CredentialSource = "profile"
+
+	// SourceForwarded forwards the authorization metadata of the incoming
+	// request.
+	SourceForwarded CredentialSource = "forwarded"
+)
+
+// SkippedSource records why a credential source was not used.
+type SkippedSource struct {
+	Source CredentialSource
+	Reason error
+}
+
+// CredentialsReport describes how FindDefaultCredentials chose credentials.
+type CredentialsReport struct {
+	Source  CredentialSource
+	Skipped []SkippedSource
+}
+
+func (r *CredentialsReport) String() string {
+	s := fmt.Sprintf("using %s credentials", r.Source)
+	for _, skipped := range r.Skipped {
+		s += fmt.Sprintf("; skipped %s: %v", skipped.Source, skipped.Reason)
+	}
+
+	return s
+}
+
+type accessTokenCredentials string
+
+// NewAccessTokenCredentials returns per-RPC credentials that always send
+// accessToken.
+func NewAccessTokenCredentials(accessToken string) credentials.PerRPCCredentials {
+	return accessTokenCredentials(accessToken)
+}
+
+func (c accessTokenCredentials) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	return map[string]string{
+		"authorization": string(c),
+	}, nil
+}
+
+func (accessTokenCredentials) RequireTransportSecurity() bool {
+	return true
+}
+
+var errAccessTokenUnset = errors.New("TOPOS_ACCESS_TOKEN is not set")
+
+func (options *dialOptions) findDefaultCredentials() (credentials.PerRPCCredentials, *CredentialsReport, error) {
+	report := &CredentialsReport{}
+	skip := func(source CredentialSource, reason error) {
+		report.Skipped = append(report.Skipped, SkippedSource{
+			Source: source,
+			Reason: reason,
+		})
+	}
+
+	if accessToken := os.Getenv("TOPOS_ACCESS_TOKEN"); accessToken != "" {
+		report.Source = SourceEnvironment
+		return NewAccessTokenCredentials(accessToken), report, nil
+	}
+
+	skip(SourceEnvironment, errAccessTokenUnset)
+
+	config, err := options.loadConfig()
+	if err != nil {
+		return nil, report, err
+	}
+
+	account := options.serviceAccount
+	if account == nil {
+		account, err = LoadServiceAccount(config)
+	}
+
+	if err == nil {
+		report.Source = SourceServiceAccount
+		return options.serviceAccountCredentials(config, account), report, nil
+	}
+
+	// A configured but unreadable service account is an error rather than a
+	// reason to fall back to the user's own credentials.
+	if err != ErrNoServiceAccount {
+		return nil, report, err
+	}
+
+	skip(SourceServiceAccount, err)
+
+	creds, err := options.localCredentials(config)
+	if err == nil {
+		report.Source = SourceProfile
+		return creds, report, nil
+	}
+
+	skip(SourceProfile, fmt.Errorf("profile %s: %v", activeProfile(options.profile), err))
+
+	report.Source = SourceForwarded
+	return remoteCredentials{}, report, nil
+}
+
+// FindDefaultCredentials returns the first available of, in order: the
+// access token in TOPOS_ACCESS_TOKEN, a service account (see
+// LoadServiceAccount), the profile written by Login, and forwarding of the
+// incoming request's credentials. The report says which source was chosen and
+// why the ones before it were skipped. Dial options such as DialWithProfile
+// and DialWithConfig apply.
+func FindDefaultCredentials(opts ...DialOption) (credentials.PerRPCCredentials, *CredentialsReport, error) {
+	options := &dialOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	return options.findDefaultCredentials()
+}
@@ -0,0 +1,146 @@
+package auth
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Audience is the aud claim of a token, which may be encoded as a single
+// string or as an array of strings.
+type Audience []string
+
+func (a *Audience) UnmarshalJSON(data []byte) error {
+	var audience string
+	if err := json.Unmarshal(data, &audience); err == nil {
+		*a = Audience{audience}
+		return nil
+	}
+
+	var audiences []string
+	if err := json.Unmarshal(data, &audiences); err != nil {
+		return err
+	}
+
+	*a = Audience(audiences)
+	return nil
+}
+
+// Contains reports whether audience is one of the token's audiences.
+func (a Audience) Contains(audience string) bool {
+	for _, aud := range a {
+		if aud == audience {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Claims are the claims of a Topos access token.
+type Claims struct {
+	Issuer          string   `json:"iss,omitempty"`
+	Subject         string   `json:"sub,omitempty"`
+	Audience        Audience `json:"aud,omitempty"`
+	ExpiresAt       int64    `json:"exp,omitempty"`
+	IssuedAt        int64    `json:"iat,omitempty"`
+	NotBefore       int64    `json:"nbf,omitempty"`
+	AuthorizedParty string   `json:"azp,omitempty"`
+	Scope           string   `json:"scope,omitempty"`
+	Permissions     []string `json:"permissions,omitempty"`
+
+	OrganizationID   string `json:"org_id,omitempty"`
+	OrganizationName string `json:"org_name,omitempty"`
+
+	// Raw holds every claim of the token, including those without a field.
+	Raw map[string]interface{} `json:"-"`
+}
+
+// IssuedAtTime returns the iat claim as a time.Time.
+func (c *Claims) IssuedAtTime() time.Time {
+	return time.Unix(c.IssuedAt, 0)
+}
+
+// ExpiresAtTime returns the exp claim as a time.Time.
+func (c *Claims) ExpiresAtTime() time.Time {
+	return time.Unix(c.ExpiresAt, 0)
+}
+
+// Tenant returns the tenant claim, either a plain tenant claim or a
+// namespaced one such as https://topos.com/tenant.
+func (c *Claims) Tenant() string {
+	if tenant, ok := c.Raw["tenant"].(string); ok {
+		return tenant
+	}
+
+	for name, value := range c.Raw {
+		if tenant, ok := value.(string); ok && strings.HasSuffix(name, "/tenant") {
+			return tenant
+		}
+	}
+
+	return ""
+}
+
+// Scopes returns the space separated scope claim together with the
+// permissions claim.
+func (c *Claims) Scopes() []string {
+	return append(strings.Fields(c.Scope), c.Permissions...)
+}
+
+// HasScope reports whether the token was granted scope.
+func (c *Claims) HasScope(scope string) bool {
+	for _, s := range c.Scopes() {
+		if s == scope {
+			return true
+		}
+	}
+
+	return false
+}
+
+func parseClaims(payload []byte) (*Claims, error) {
+	claims := &Claims{}
+	if err := json.Unmarshal(payload, claims); err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(payload, &claims.Raw); err != nil {
+		return nil, err
+	}
+
+	return claims, nil
+}
+
+// ParseUnverifiedClaims decodes the claims of a JWT without verifying its
+// signature. It must not be used to make authorization decisions.
+func ParseUnverifiedClaims(token string) (*Claims, error) {
+	components := strings.SplitN(token, ".", 3)
+	if len(components) != 3 {
+		return nil, fmt.Errorf("invalid access_token")
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(components[1])
+	if err != nil {
+		return nil, err
+	}
+
+	return parseClaims(payload)
+}
+
+type claimsKey struct{}
+
+// NewContextWithClaims returns a copy of ctx carrying claims.
+func NewContextWithClaims(ctx context.Context, claims *Claims) context.Context {
+	return context.WithValue(ctx, claimsKey{}, claims)
+}
+
+// ClaimsFromContext returns the verified claims stored in ctx by the server
+// interceptors.
+func ClaimsFromContext(ctx context.Context) (*Claims, bool) {
+	claims, ok := ctx.Value(claimsKey{}).(*Claims)
+	return claims, ok
+}
@@ -0,0 +1,178 @@
+package auth
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	toposIssuer       string = "https://auth.topos.com"
+	toposClientID     string = "tJbqmqfttOHJ0kGfy8Bvf60v8Z4pW7T4"
+	toposAudience     string = "https://endpoints.topos.com"
+	toposCallbackPort int    = 8676
+)
+
+// Config describes the OAuth environment used to obtain Topos access tokens.
+// Empty endpoint URLs are derived from Issuer. TokenStore selects where tokens
+// are kept: "file" (the default) or "secret-service", which is only available
+// on Linux, NetBSD and OpenBSD.
+type Config struct {
+	Issuer                 string `json:"issuer,omitempty"`
+	ClientID               string `json:"client_id,omitempty"`
+	Audience               string `json:"audience,omitempty"`
+	CallbackPort           int    `json:"callback_port,omitempty"`
+	AuthorizeURL           string `json:"authorize_url,omitempty"`
+	TokenURL               string `json:"token_url,omitempty"`
+	DeviceAuthorizationURL string `json:"device_authorization_url,omitempty"`
+	RevocationURL          string `json:"revocation_url,omitempty"`
+	JWKSURL                string `json:"jwks_url,omitempty"`
+	ServiceAccountFile     string `json:"service_account_file,omitempty"`
+	TokenStore             string `json:"token_store,omitempty"`
+}
+
+// DefaultConfig returns the production Topos configuration.
+func DefaultConfig() *Config {
+	return &Config{
+		Issuer:       toposIssuer,
+		ClientID:     toposClientID,
+		Audience:     toposAudience,
+		CallbackPort: toposCallbackPort,
+	}
+}
+
+func toposDirectory() (string, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(homeDir, ".topos"), nil
+}
+
+func configPath(profile string) (string, error) {
+	if path := os.Getenv("TOPOS_AUTH_CONFIG"); path != "" {
+		return path, nil
+	}
+
+	profileDir, err := profileDirectory(profile)
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(profileDir, "config.json"), nil
+}
+
+// LoadConfigFile reads a JSON encoded Config from path and merges it over the
+// default configuration.
+func LoadConfigFile(path string) (*Config, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	defer file.Close()
+	c := DefaultConfig()
+	if err := json.NewDecoder(file).Decode(c); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	return c, nil
+}
+
+// LoadConfig loads the configuration of the profile named by TOPOS_PROFILE, or
+// of DefaultProfile.
+func LoadConfig() (*Config, error) {
+	return LoadProfileConfig("")
+}
+
+// LoadProfileConfig returns the default configuration overridden by the
+// profile's config file (~/.topos/config.json for DefaultProfile,
+// ~/.topos/profiles/<name>/config.json otherwise, or $TOPOS_AUTH_CONFIG) if
+// present, and then by the TOPOS_AUTH_* environment variables. An empty
+// profile selects TOPOS_PROFILE or DefaultProfile.
+func LoadProfileConfig(profile string) (*Config, error) {
+	path, err := configPath(activeProfile(profile))
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := LoadConfigFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return nil, err
+		}
+
+		c = DefaultConfig()
+	}
+
+	if err := c.applyEnv(); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+func (c *Config) applyEnv() error {
+	for env, field := range map[string]*string{
+		"TOPOS_AUTH_ISSUER":                   &c.Issuer,
+		"TOPOS_AUTH_CLIENT_ID":                &c.ClientID,
+		"TOPOS_AUTH_AUDIENCE":                 &c.Audience,
+		"TOPOS_AUTH_AUTHORIZE_URL":            &c.AuthorizeURL,
+		"TOPOS_AUTH_TOKEN_URL":                &c.TokenURL,
+		"TOPOS_AUTH_DEVICE_AUTHORIZATION_URL": &c.DeviceAuthorizationURL,
+		"TOPOS_AUTH_REVOCATION_URL":           &c.RevocationURL,
+		"TOPOS_AUTH_JWKS_URL":                 &c.JWKSURL,
+		"TOPOS_TOKEN_STORE":                   &c.TokenStore,
+	} {
+		if value := os.Getenv(env); value != "" {
+			*field = value
+		}
+	}
+
+	if value := os.Getenv("TOPOS_AUTH_CALLBACK_PORT"); value != "" {
+		port, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("TOPOS_AUTH_CALLBACK_PORT: %v", err)
+		}
+
+		c.CallbackPort = port
+	}
+
+	return nil
+}
+
+func (c *Config) endpoint(override, path string) string {
+	if override != "" {
+		return override
+	}
+
+	return strings.TrimSuffix(c.Issuer, "/") + path
+}
+
+func (c *Config) authorizeURL() string {
+	return c.endpoint(c.AuthorizeURL, "/authorize")
+}
+
+func (c *Config) tokenURL() string {
+	return c.endpoint(c.TokenURL, "/oauth/token")
+}
+
+func (c *Config) deviceAuthorizationURL() string {
+	return c.endpoint(c.DeviceAuthorizationURL, "/oauth/device/code")
+}
+
+func (c *Config) revocationURL() string {
+	return c.endpoint(c.RevocationURL, "/oauth/revoke")
+}
+
+func (c *Config) jwksURL() string {
+	return c.endpoint(c.JWKSURL, "/.well-known/jwks.json")
+}
+
+func callbackURL(port int) string {
+	return fmt.Sprintf("http://localhost:%d/callback", port)
+}
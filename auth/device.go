@@ -0,0 +1,103 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"time"
+)
+
+const (
+	deviceCodeGrantType     string = "urn:ietf:params:oauth:grant-type:device_code"
+	defaultDevicePollPeriod        = 5 * time.Second
+	devicePollSlowDown             = 5 * time.Second
+)
+
+type deviceCodeResponse struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete,omitempty"`
+	ExpiresIn               int64  `json:"expires_in"`
+	Interval                int64  `json:"interval,omitempty"`
+}
+
+func (c *Config) getDeviceCode(ctx context.Context) (*deviceCodeResponse, error) {
+	payload := url.Values{}
+	payload.Set("audience", c.Audience)
+	payload.Set("client_id", c.ClientID)
+	payload.Add("scope", "offline_access")
+
+	d := &deviceCodeResponse{}
+	if err := postForm(ctx, c.deviceAuthorizationURL(), payload, d); err != nil {
+		return nil, err
+	}
+
+	return d, nil
+}
+
+func (c *Config) getTokenDevice(ctx context.Context, w io.Writer) (*getTokenResponse, error) {
+	deviceCode, err := c.getDeviceCode(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if deviceCode.VerificationURIComplete != "" {
+		fmt.Fprintf(w, "To log in, visit %s\n", deviceCode.VerificationURIComplete)
+		fmt.Fprintf(w, "and confirm the code %s\n", deviceCode.UserCode)
+	} else {
+		fmt.Fprintf(w, "To log in, visit %s\n", deviceCode.VerificationURI)
+		fmt.Fprintf(w, "and enter the code %s\n", deviceCode.UserCode)
+	}
+
+	interval := defaultDevicePollPeriod
+	if deviceCode.Interval > 0 {
+		interval = time.Duration(deviceCode.Interval) * time.Second
+	}
+
+	if deviceCode.ExpiresIn > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(deviceCode.ExpiresIn)*time.Second)
+		defer cancel()
+	}
+
+	payload := url.Values{}
+	payload.Set("client_id", c.ClientID)
+	payload.Set("device_code", deviceCode.DeviceCode)
+	payload.Set("grant_type", deviceCodeGrantType)
+
+	timer := time.NewTimer(interval)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+
+		response, err := c.getToken(ctx, payload)
+		if err == nil {
+			return response, nil
+		}
+
+		var tokenErr *TokenError
+		if !errors.As(err, &tokenErr) {
+			return nil, err
+		}
+
+		switch {
+		case tokenErr.Code == "authorization_pending":
+		case tokenErr.Code == "slow_down" || tokenErr.unprocessed():
+			interval += devicePollSlowDown
+			if tokenErr.RetryAfter > interval {
+				interval = tokenErr.RetryAfter
+			}
+		default:
+			return nil, err
+		}
+
+		timer.Reset(interval)
+	}
+}
@@ -0,0 +1,404 @@
+package auth
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+
+	"golang.org/x/oauth2"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/keepalive"
+)
+
+const userAgent string = "topos-apis-go"
+
+type dialOptions struct {
+	config         *Config
+	serviceAccount *ServiceAccount
+	perRPC         credentials.PerRPCCredentials
+	store          TokenStore
+	profile        string
+	refreshPolicy  *RefreshPolicy
+	refreshObserve RefreshObserver
+	defaultCreds   bool
+	tokenExchange  bool
+	scopeResolver  ScopeResolver
+	exchangeOpts   []ExchangeOption
+
+	transportSecurity  TransportSecurity
+	rootCAs            *x509.CertPool
+	certificates       []tls.Certificate
+	unaryInterceptors  []grpc.UnaryClientInterceptor
+	streamInterceptors []grpc.StreamClientInterceptor
+	userAgent          string
+	keepalive          *keepalive.ClientParameters
+	retryPolicy        *RetryPolicy
+	limiters           limiters
+	callOptions        []grpc.CallOption
+	grpcOptions        []grpc.DialOption
+}
+
+type DialOption func(*dialOptions)
+
+// DialWithTransportSecurity sets how the connection is secured. Defaults to
+// TransportSecurityAuto.
+func DialWithTransportSecurity(security TransportSecurity) DialOption {
+	return func(options *dialOptions) {
+		options.transportSecurity = security
+	}
+}
+
+// DialWithRootCAs verifies the server's certificate against pool instead of
+// the system roots.
+func DialWithRootCAs(pool *x509.CertPool) DialOption {
+	return func(options *dialOptions) {
+		options.rootCAs = pool
+	}
+}
+
+// DialWithClientCertificate presents cert to the server for mutual TLS.
+func DialWithClientCertificate(cert tls.Certificate) DialOption {
+	return func(options *dialOptions) {
+		options.certificates = append(options.certificates, cert)
+	}
+}
+
+// DialWithUnaryInterceptors adds client interceptors to unary RPCs, run in
+// the order given.
+func DialWithUnaryInterceptors(interceptors ...grpc.UnaryClientInterceptor) DialOption {
+	return func(options *dialOptions) {
+		options.unaryInterceptors = append(options.unaryInterceptors, interceptors...)
+	}
+}
+
+// DialWithStreamInterceptors adds client interceptors to streaming RPCs, run
+// in the order given.
+func DialWithStreamInterceptors(interceptors ...grpc.StreamClientInterceptor) DialOption {
+	return func(options *dialOptions) {
+		options.streamInterceptors = append(options.streamInterceptors, interceptors...)
+	}
+}
+
+// DialWithUserAgent appends suffix to the user agent sent to the server.
+func DialWithUserAgent(suffix string) DialOption {
+	return func(options *dialOptions) {
+		options.userAgent = suffix
+	}
+}
+
+// DialWithKeepalive sets the connection's keepalive parameters.
+func DialWithKeepalive(params keepalive.ClientParameters) DialOption {
+	return func(options *dialOptions) {
+		options.keepalive = &params
+	}
+}
+
+// DialWithRetryPolicy sets how failed idempotent RPCs are retried.
+// Defaults to DefaultRetryPolicy.
+func DialWithRetryPolicy(policy RetryPolicy) DialOption {
+	return func(options *dialOptions) {
+		options.retryPolicy = &policy
+	}
+}
+
+// DialWithLimiter throttles every RPC of the client with limiter. Clients
+// sharing a limiter are throttled together.
+func DialWithLimiter(limiter *Limiter) DialOption {
+	return func(options *dialOptions) {
+		options.limiters.client = limiter
+	}
+}
+
+// DialWithMethodLimiter throttles the RPCs of the full gRPC method name, such
+// as /topos.points.v1.Points/SetPoint, with limiter in addition to the
+// client's limiter.
+func DialWithMethodLimiter(method string, limiter *Limiter) DialOption {
+	return func(options *dialOptions) {
+		if options.limiters.methods == nil {
+			options.limiters.methods = map[string]*Limiter{}
+		}
+
+		options.limiters.methods[method] = limiter
+	}
+}
+
+// DialWithMaxMessageSize limits the size of messages received and sent by
+// every RPC.
+func DialWithMaxMessageSize(recv, send int) DialOption {
+	return func(options *dialOptions) {
+		options.callOptions = append(options.callOptions, grpc.MaxCallRecvMsgSize(recv), grpc.MaxCallSendMsgSize(send))
+	}
+}
+
+// DialWithGRPCOptions passes additional options to grpc.Dial. They are
+// applied after, and so take precedence over, those derived from other
+// DialOptions.
+func DialWithGRPCOptions(opts ...grpc.DialOption) DialOption {
+	return func(options *dialOptions) {
+		options.grpcOptions = append(options.grpcOptions, opts...)
+	}
+}
+
+// DialWithConfig sets the OAuth environment credentials are obtained from.
+// Defaults to the profile's configuration, see LoadProfileConfig.
+func DialWithConfig(config *Config) DialOption {
+	return func(options *dialOptions) {
+		options.config = config
+	}
+}
+
+// DialWithProfile uses the local credentials of the named profile. Defaults to
+// TOPOS_PROFILE or DefaultProfile.
+func DialWithProfile(profile string) DialOption {
+	return func(options *dialOptions) {
+		options.profile = profile
+	}
+}
+
+// DialWithTokenStore sets where local credentials are read from and refreshed
+// tokens are saved. Defaults to the config's token store.
+func DialWithTokenStore(store TokenStore) DialOption {
+	return func(options *dialOptions) {
+		options.store = store
+	}
+}
+
+// DialWithRefreshPolicy sets when cached access tokens are refreshed.
+// Defaults to DefaultRefreshPolicy.
+func DialWithRefreshPolicy(policy RefreshPolicy) DialOption {
+	return func(options *dialOptions) {
+		options.refreshPolicy = &policy
+	}
+}
+
+// DialWithRefreshObserver calls observe after every access token refresh.
+func DialWithRefreshObserver(observe RefreshObserver) DialOption {
+	return func(options *dialOptions) {
+		options.refreshObserve = observe
+	}
+}
+
+// DialWithServiceAccount authenticates every RPC as the service account using
+// the client credentials grant.
+func DialWithServiceAccount(account *ServiceAccount) DialOption {
+	return func(options *dialOptions) {
+		options.serviceAccount = account
+	}
+}
+
+// DialWithTokenSource authenticates every RPC with the access tokens of an
+// oauth2.TokenSource.
+func DialWithTokenSource(source oauth2.TokenSource) DialOption {
+	return DialWithPerRPCCredentials(NewTokenSourceCredentials(source))
+}
+
+// DialWithPerRPCCredentials authenticates every RPC with creds.
+func DialWithPerRPCCredentials(creds credentials.PerRPCCredentials) DialOption {
+	return func(options *dialOptions) {
+		options.perRPC = creds
+	}
+}
+
+// DialWithDefaultCredentials resolves credentials with FindDefaultCredentials
+// instead of the useLocalCredentials argument.
+func DialWithDefaultCredentials() DialOption {
+	return func(options *dialOptions) {
+		options.defaultCreds = true
+	}
+}
+
+// DialWithTokenScope requests the access tokens of local and service account
+// credentials for scope instead of the config's audience.
+func DialWithTokenScope(scope TokenScope) DialOption {
+	return DialWithScopeResolver(StaticTokenScope(scope))
+}
+
+// DialWithScopeResolver requests a separate access token for the scope
+// resolve returns for each RPC, for example AudienceFromURI. Tokens for the
+// zero TokenScope are the ones shared with the profile's token store.
+func DialWithScopeResolver(resolve ScopeResolver) DialOption {
+	return func(options *dialOptions) {
+		options.scopeResolver = resolve
+	}
+}
+
+// DialWithTokenExchange authenticates every RPC with a token exchanged for
+// the incoming request's authorization, see NewTokenExchangeCredentials. The
+// exchange is authenticated as the service account set with
+// DialWithServiceAccount or found by LoadServiceAccount, if any.
+func DialWithTokenExchange(opts ...ExchangeOption) DialOption {
+	return func(options *dialOptions) {
+		options.tokenExchange = true
+		options.exchangeOpts = opts
+	}
+}
+
+func (options *dialOptions) loadConfig() (*Config, error) {
+	if options.config != nil {
+		return options.config, nil
+	}
+
+	return LoadProfileConfig(options.profile)
+}
+
+func (options *dialOptions) policy() RefreshPolicy {
+	if options.refreshPolicy != nil {
+		return *options.refreshPolicy
+	}
+
+	return DefaultRefreshPolicy
+}
+
+func (options *dialOptions) localCredentials(config *Config) (*localCredentials, error) {
+	store := options.store
+	if store == nil {
+		var err error
+		store, err = config.tokenStore(activeProfile(options.profile))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	creds, err := newLocalCredentials(config, store, options.policy(), options.refreshObserve)
+	if err != nil {
+		return nil, err
+	}
+
+	if options.scopeResolver != nil {
+		creds.setScopeResolver(options.scopeResolver)
+	}
+
+	return creds, nil
+}
+
+func (options *dialOptions) serviceAccountCredentials(config *Config, account *ServiceAccount) *serviceAccountCredentials {
+	creds := newServiceAccountCredentials(config, account, options.policy(), options.refreshObserve)
+	if options.scopeResolver != nil {
+		creds.setScopeResolver(options.scopeResolver)
+	}
+
+	return creds
+}
+
+func (options *dialOptions) perRPCCredentials(useLocalCredentials bool) (credentials.PerRPCCredentials, error) {
+	if options.perRPC != nil {
+		return options.perRPC, nil
+	}
+
+	if options.defaultCreds {
+		creds, _, err := options.findDefaultCredentials()
+		return creds, err
+	}
+
+	if options.tokenExchange {
+		return options.exchangeCredentials()
+	}
+
+	if options.serviceAccount == nil && !useLocalCredentials {
+		return remoteCredentials{}, nil
+	}
+
+	config, err := options.loadConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	if options.serviceAccount != nil {
+		return options.serviceAccountCredentials(config, options.serviceAccount), nil
+	}
+
+	return options.localCredentials(config)
+}
+
+func (options *dialOptions) exchangeCredentials() (credentials.PerRPCCredentials, error) {
+	config, err := options.loadConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	account := options.serviceAccount
+	if account == nil {
+		account, err = LoadServiceAccount(config)
+		if err != nil && err != ErrNoServiceAccount {
+			return nil, err
+		}
+	}
+
+	opts := append([]ExchangeOption{
+		ExchangeWithRefreshPolicy(options.policy()),
+		ExchangeWithRefreshObserver(options.refreshObserve),
+	}, options.exchangeOpts...)
+
+	return NewTokenExchangeCredentials(config, account, opts...), nil
+}
+
+// Credentials returns the per-RPC credentials Dial would use with the same
+// arguments.
+func Credentials(useLocalCredentials bool, opts ...DialOption) (credentials.PerRPCCredentials, error) {
+	options := &dialOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	return options.perRPCCredentials(useLocalCredentials)
+}
+
+func (options *dialOptions) grpcDialOptions(addr string, creds credentials.PerRPCCredentials) ([]grpc.DialOption, error) {
+	grpcOptions, err := options.transportOptions(addr, creds)
+	if err != nil {
+		return nil, err
+	}
+
+	ua := userAgent
+	if options.userAgent != "" {
+		ua += " " + options.userAgent
+	}
+
+	grpcOptions = append(grpcOptions, grpc.WithUserAgent(ua))
+
+	retryPolicy := DefaultRetryPolicy
+	if options.retryPolicy != nil {
+		retryPolicy = *options.retryPolicy
+	}
+
+	// Retries and limiters are the innermost interceptors, so that the others
+	// see one call and every attempt is throttled.
+	unaryInterceptors := append(options.unaryInterceptors[:len(options.unaryInterceptors):len(options.unaryInterceptors)], retryPolicy.UnaryClientInterceptor())
+	streamInterceptors := append(options.streamInterceptors[:len(options.streamInterceptors):len(options.streamInterceptors)], retryPolicy.StreamClientInterceptor())
+	if !options.limiters.empty() {
+		unaryInterceptors = append(unaryInterceptors, options.limiters.unaryClientInterceptor())
+		streamInterceptors = append(streamInterceptors, options.limiters.streamClientInterceptor())
+	}
+
+	grpcOptions = append(grpcOptions, grpc.WithChainUnaryInterceptor(unaryInterceptors...))
+	grpcOptions = append(grpcOptions, grpc.WithChainStreamInterceptor(streamInterceptors...))
+
+	if options.keepalive != nil {
+		grpcOptions = append(grpcOptions, grpc.WithKeepaliveParams(*options.keepalive))
+	}
+
+	if len(options.callOptions) > 0 {
+		grpcOptions = append(grpcOptions, grpc.WithDefaultCallOptions(options.callOptions...))
+	}
+
+	return append(grpcOptions, options.grpcOptions...), nil
+}
+
+func Dial(addr string, useLocalCredentials bool, opts ...DialOption) (*grpc.ClientConn, error) {
+	options := &dialOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	creds, err := options.perRPCCredentials(useLocalCredentials)
+	if err != nil {
+		return nil, err
+	}
+
+	grpcOptions, err := options.grpcDialOptions(addr, creds)
+	if err != nil {
+		return nil, err
+	}
+
+	return grpc.Dial(addr, grpcOptions...)
+}
@@ -0,0 +1,205 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	postFormAttempts     = 3
+	postFormBackoff      = 500 * time.Millisecond
+	postFormMaxRetryWait = 30 * time.Second
+)
+
+// Errors matched by TokenError with errors.Is.
+var (
+	// ErrInvalidGrant is returned when the issuer rejects an authorization
+	// code, device code or refresh token.
+	ErrInvalidGrant = errors.New("invalid grant")
+
+	// ErrInvalidRefreshToken is returned when the stored refresh token has
+	// expired or been revoked. The user must log in again.
+	ErrInvalidRefreshToken = errors.New("refresh token expired or revoked, log in again")
+
+	// ErrAccessDenied is returned when the user or the issuer denied the
+	// authorization request.
+	ErrAccessDenied = errors.New("access denied")
+
+	// ErrInvalidClient is returned when the client ID or secret is wrong.
+	ErrInvalidClient = errors.New("invalid client")
+
+	// ErrRateLimited is returned when the issuer throttled the request.
+	// TokenError.RetryAfter holds how long to wait, if the issuer said.
+	ErrRateLimited = errors.New("rate limited")
+
+	// ErrServerError is returned when the issuer failed or was unavailable.
+	ErrServerError = errors.New("authorization server error")
+)
+
+// TokenError is returned when an OAuth endpoint of the issuer rejects a
+// request. Use errors.Is with the Err variables of this package to classify
+// it.
+type TokenError struct {
+	StatusCode  int           `json:"-"`
+	Code        string        `json:"error"`
+	Description string        `json:"error_description,omitempty"`
+	RetryAfter  time.Duration `json:"-"`
+
+	// GrantType is the grant_type of the failed request, if any.
+	GrantType string `json:"-"`
+}
+
+func (err *TokenError) Error() string {
+	code := err.Code
+	if code == "" {
+		code = fmt.Sprintf("status %d", err.StatusCode)
+	}
+
+	if err.Description == "" {
+		return fmt.Sprintf("oauth error %s", code)
+	}
+
+	return fmt.Sprintf("oauth error %s: %s", code, err.Description)
+}
+
+func (err *TokenError) Is(target error) bool {
+	switch target {
+	case ErrInvalidGrant:
+		return err.Code == "invalid_grant"
+	case ErrInvalidRefreshToken:
+		return err.Code == "invalid_grant" && err.GrantType == "refresh_token"
+	case ErrAccessDenied:
+		return err.Code == "access_denied" || err.Code == "unauthorized_client"
+	case ErrInvalidClient:
+		return err.Code == "invalid_client"
+	case ErrRateLimited:
+		return err.StatusCode == http.StatusTooManyRequests || err.Code == "too_many_requests"
+	case ErrServerError:
+		return err.StatusCode >= 500 || err.Code == "server_error" || err.Code == "temporarily_unavailable"
+	default:
+		return false
+	}
+}
+
+// unprocessed reports whether the issuer turned the request away without
+// processing it, so that it is safe to send again.
+func (err *TokenError) unprocessed() bool {
+	return err.StatusCode == http.StatusTooManyRequests || err.StatusCode == http.StatusServiceUnavailable || err.Code == "temporarily_unavailable"
+}
+
+// retryable reports whether payload may be posted again after err. Requests
+// the issuer did not process are retried. After other failures, such as a
+// server error or a lost response, the issuer may already have used the
+// authorization code or rotated the refresh token, so those grants are not
+// sent again; other grants are. Device code polls are retried by the polling
+// loop at the interval the issuer asks for.
+func retryable(err error, payload url.Values) bool {
+	grantType := payload.Get("grant_type")
+	if grantType == deviceCodeGrantType {
+		return false
+	}
+
+	replayable := grantType != "authorization_code" && grantType != "refresh_token"
+
+	var tokenErr *TokenError
+	if errors.As(err, &tokenErr) {
+		return tokenErr.unprocessed() || replayable && errors.Is(err, ErrServerError)
+	}
+
+	return replayable
+}
+
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(seconds) * time.Second
+	}
+
+	if date, err := http.ParseTime(value); err == nil {
+		return time.Until(date)
+	}
+
+	return 0
+}
+
+func postFormOnce(ctx context.Context, endpoint string, payload url.Values, v interface{}) error {
+	req, err := http.NewRequest("POST", endpoint, strings.NewReader(payload.Encode()))
+	if err != nil {
+		return err
+	}
+
+	req = req.WithContext(ctx)
+	req.Header.Add("content-type", "application/x-www-form-urlencoded")
+	response, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		tokenErr := &TokenError{
+			StatusCode: response.StatusCode,
+			RetryAfter: parseRetryAfter(response.Header.Get("retry-after")),
+			GrantType:  payload.Get("grant_type"),
+		}
+
+		// The body is informative only; a non-JSON body leaves Code empty.
+		json.NewDecoder(io.LimitReader(response.Body, 1<<16)).Decode(tokenErr)
+		return tokenErr
+	}
+
+	if v == nil {
+		_, err := io.Copy(ioutil.Discard, response.Body)
+		return err
+	}
+
+	return json.NewDecoder(response.Body).Decode(v)
+}
+
+// postForm posts payload to endpoint and decodes the JSON response into v.
+// Retryable failures are retried with exponential backoff, honoring
+// Retry-After.
+func postForm(ctx context.Context, endpoint string, payload url.Values, v interface{}) error {
+	backoff := postFormBackoff
+	for attempt := 1; ; attempt++ {
+		err := postFormOnce(ctx, endpoint, payload, v)
+		if err == nil || attempt == postFormAttempts || ctx.Err() != nil || !retryable(err, payload) {
+			return err
+		}
+
+		wait := backoff/2 + time.Duration(rand.Int63n(int64(backoff)))
+		var tokenErr *TokenError
+		if errors.As(err, &tokenErr) {
+			if tokenErr.RetryAfter > postFormMaxRetryWait {
+				return err
+			}
+
+			if tokenErr.RetryAfter > 0 {
+				wait = tokenErr.RetryAfter
+			}
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+
+		backoff *= 2
+	}
+}
@@ -0,0 +1,221 @@
+package auth
+
+import (
+	"context"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/credentials"
+)
+
+const (
+	tokenExchangeGrantType = "urn:ietf:params:oauth:grant-type:token-exchange"
+	accessTokenType        = "urn:ietf:params:oauth:token-type:access_token"
+
+	// exchangeSweepInterval is how often expired exchanged tokens are
+	// dropped from the cache.
+	exchangeSweepInterval = time.Minute
+)
+
+type exchangeOptions struct {
+	audience string
+	scopes   []string
+	policy   RefreshPolicy
+	observe  RefreshObserver
+}
+
+type ExchangeOption func(*exchangeOptions)
+
+// ExchangeWithAudience sets the audience of exchanged tokens. Defaults to the
+// config's audience.
+func ExchangeWithAudience(audience string) ExchangeOption {
+	return func(options *exchangeOptions) {
+		options.audience = audience
+	}
+}
+
+// ExchangeWithScopes requests exchanged tokens limited to scopes. Defaults to
+// the scopes the issuer grants for the subject token.
+func ExchangeWithScopes(scopes ...string) ExchangeOption {
+	return func(options *exchangeOptions) {
+		options.scopes = scopes
+	}
+}
+
+// ExchangeWithRefreshPolicy sets when exchanged tokens are exchanged again.
+// Defaults to DefaultRefreshPolicy.
+func ExchangeWithRefreshPolicy(policy RefreshPolicy) ExchangeOption {
+	return func(options *exchangeOptions) {
+		options.policy = policy
+	}
+}
+
+// ExchangeWithRefreshObserver calls observe after every exchange.
+func ExchangeWithRefreshObserver(observe RefreshObserver) ExchangeOption {
+	return func(options *exchangeOptions) {
+		options.observe = observe
+	}
+}
+
+type exchangeKey struct {
+	subjectToken string
+	audience     string
+}
+
+type exchangedToken struct {
+	cache *tokenCache
+
+	// subjectExpiry is when the subject token expires, after which the entry
+	// can no longer be used. It is zero for opaque subject tokens.
+	subjectExpiry int64
+}
+
+// expired reports whether the entry can be dropped: its subject token
+// expired, or, for an opaque subject token, no exchange is running and the
+// exchanged token expired or was never obtained. A caller still presenting
+// an opaque subject token exchanges it again.
+func (t *exchangedToken) expired(now int64) bool {
+	if t.subjectExpiry != 0 {
+		return t.subjectExpiry < now
+	}
+
+	t.cache.lock.Lock()
+	defer t.cache.lock.Unlock()
+
+	return t.cache.refreshed == nil && t.cache.expiry < now
+}
+
+type exchangeCredentials struct {
+	config  *Config
+	account *ServiceAccount
+	options *exchangeOptions
+
+	lock    sync.Mutex
+	tokens  map[exchangeKey]*exchangedToken
+	sweptAt time.Time
+}
+
+// NewTokenExchangeCredentials returns per-RPC credentials that exchange the
+// authorization of the incoming request for a token delegated to this
+// service, using the OAuth token exchange grant (RFC 8693). The service
+// authenticates with account, or only with the config's client ID if account
+// is nil. Exchanged tokens are cached per subject token and audience and
+// exchanged again before they expire.
+func NewTokenExchangeCredentials(config *Config, account *ServiceAccount, opts ...ExchangeOption) credentials.PerRPCCredentials {
+	options := &exchangeOptions{
+		audience: config.Audience,
+		policy:   DefaultRefreshPolicy,
+	}
+
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	return &exchangeCredentials{
+		config:  config,
+		account: account,
+		options: options,
+		tokens:  map[exchangeKey]*exchangedToken{},
+	}
+}
+
+func (c *exchangeCredentials) exchange(ctx context.Context, subjectToken, audience string) (string, int64, error) {
+	payload := url.Values{}
+	payload.Set("grant_type", tokenExchangeGrantType)
+	payload.Set("subject_token", subjectToken)
+	payload.Set("subject_token_type", accessTokenType)
+	payload.Set("requested_token_type", accessTokenType)
+	if audience != "" {
+		payload.Set("audience", audience)
+	}
+
+	if len(c.options.scopes) > 0 {
+		payload.Set("scope", strings.Join(c.options.scopes, " "))
+	}
+
+	if c.account != nil {
+		payload.Set("client_id", c.account.ClientID)
+		payload.Set("client_secret", c.account.ClientSecret)
+	} else {
+		payload.Set("client_id", c.config.ClientID)
+	}
+
+	now := time.Now().Unix()
+	response, err := c.config.getToken(ctx, payload)
+	if err != nil {
+		return "", 0, err
+	}
+
+	if response.ExpiresIn > 0 {
+		return response.AccessToken, now + response.ExpiresIn, nil
+	}
+
+	expiry, err := accessTokenExpiry(response.AccessToken)
+	if err != nil {
+		return "", 0, err
+	}
+
+	return response.AccessToken, expiry, nil
+}
+
+// cachedToken returns the cache of tokens exchanged for subjectToken, creating
+// it if needed. Expired entries are dropped meanwhile, at most once per
+// exchangeSweepInterval.
+func (c *exchangeCredentials) cachedToken(subjectToken, audience string) *tokenCache {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	key := exchangeKey{
+		subjectToken: subjectToken,
+		audience:     audience,
+	}
+
+	if token, ok := c.tokens[key]; ok {
+		return token.cache
+	}
+
+	if now := time.Now(); now.Sub(c.sweptAt) >= exchangeSweepInterval {
+		c.sweptAt = now
+		for key, token := range c.tokens {
+			if token.expired(now.Unix()) {
+				delete(c.tokens, key)
+			}
+		}
+	}
+
+	token := &exchangedToken{
+		cache: newTokenCache(c.options.policy, c.options.observe, func(ctx context.Context, validUntil int64) (string, int64, error) {
+			return c.exchange(ctx, subjectToken, audience)
+		}),
+	}
+
+	if expiry, err := accessTokenExpiry(subjectToken); err == nil {
+		token.subjectExpiry = expiry
+	}
+
+	c.tokens[key] = token
+	return token.cache
+}
+
+func (c *exchangeCredentials) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	authorizationMetadata := authorizationMetadataFromIncomingContext(ctx)
+	if len(authorizationMetadata) == 0 || authorizationMetadata[0] == "" {
+		return nil, ErrNoAccessToken
+	}
+
+	subjectToken := bearerToken(authorizationMetadata[0])
+	accessToken, err := c.cachedToken(subjectToken, c.options.audience).token(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]string{
+		"authorization": accessToken,
+	}, nil
+}
+
+func (*exchangeCredentials) RequireTransportSecurity() bool {
+	return true
+}
@@ -0,0 +1,133 @@
+package auth
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+
+	"google.golang.org/grpc/credentials"
+)
+
+// invalidator is implemented by credentials caching access tokens, so that a
+// token rejected by a server can be dropped and refreshed.
+type invalidator interface {
+	invalidate(accessToken string)
+}
+
+type transport struct {
+	base  http.RoundTripper
+	creds credentials.PerRPCCredentials
+}
+
+// NewTransport returns an http.RoundTripper that adds the authorization
+// header of creds to every request sent through base, or
+// http.DefaultTransport if base is nil. Requests over plain HTTP are refused
+// unless they target a loopback address. A request rejected with 401
+// Unauthorized is sent again once with a refreshed access token, provided
+// its body can be replayed.
+func NewTransport(base http.RoundTripper, creds credentials.PerRPCCredentials) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	return &transport{
+		base:  base,
+		creds: creds,
+	}
+}
+
+func closeRequestBody(req *http.Request) {
+	if req.Body != nil {
+		req.Body.Close()
+	}
+}
+
+// authorize returns a shallow copy of req carrying the authorization header
+// and the access token that was sent.
+func (t *transport) authorize(req *http.Request, body io.ReadCloser) (*http.Request, string, error) {
+	requestMetadata, err := t.creds.GetRequestMetadata(req.Context(), req.URL.Scheme+"://"+req.URL.Host)
+	if err != nil {
+		return nil, "", err
+	}
+
+	authorized := req.WithContext(req.Context())
+	authorized.Body = body
+	authorized.Header = make(http.Header, len(req.Header)+len(requestMetadata))
+	for key, values := range req.Header {
+		authorized.Header[key] = append([]string(nil), values...)
+	}
+
+	for key, value := range requestMetadata {
+		authorized.Header.Set(key, value)
+	}
+
+	return authorized, requestMetadata["authorization"], nil
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.creds.RequireTransportSecurity() && req.URL.Scheme != "https" && !isLoopback(req.URL.Host) {
+		closeRequestBody(req)
+		return nil, ErrPlaintextCredentials
+	}
+
+	authorized, accessToken, err := t.authorize(req, req.Body)
+	if err != nil {
+		closeRequestBody(req)
+		return nil, err
+	}
+
+	response, err := t.base.RoundTrip(authorized)
+	if err != nil || response.StatusCode != http.StatusUnauthorized || accessToken == "" {
+		return response, err
+	}
+
+	creds, ok := t.creds.(invalidator)
+	if !ok || (req.Body != nil && req.Body != http.NoBody && req.GetBody == nil) {
+		return response, nil
+	}
+
+	body := req.Body
+	if req.GetBody != nil {
+		if body, err = req.GetBody(); err != nil {
+			return response, nil
+		}
+	}
+
+	io.Copy(ioutil.Discard, io.LimitReader(response.Body, 1<<16))
+	response.Body.Close()
+
+	creds.invalidate(accessToken)
+	authorized, _, err = t.authorize(req, body)
+	if err != nil {
+		if body != nil {
+			body.Close()
+		}
+
+		return nil, err
+	}
+
+	return t.base.RoundTrip(authorized)
+}
+
+// HTTPClient returns an http.Client authenticating requests with the
+// credentials Dial would use with the same arguments. Without local
+// credentials the authorization of the incoming gRPC request in the request
+// context is forwarded. DialWithRootCAs, DialWithClientCertificate and
+// TransportSecuritySkipVerify configure TLS as they do for Dial.
+func HTTPClient(useLocalCredentials bool, opts ...DialOption) (*http.Client, error) {
+	options := &dialOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	creds, err := options.perRPCCredentials(useLocalCredentials)
+	if err != nil {
+		return nil, err
+	}
+
+	base := http.DefaultTransport.(*http.Transport).Clone()
+	base.TLSClientConfig = options.tlsConfig("HTTP requests")
+	return &http.Client{
+		Transport: NewTransport(base, creds),
+	}, nil
+}
@@ -0,0 +1,269 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	defaultKeySetRefreshInterval time.Duration = time.Hour
+	minKeySetRefreshInterval     time.Duration = time.Minute
+	keySetFetchTimeout           time.Duration = 30 * time.Second
+)
+
+// A KeySet resolves the public key that signed a token from its key ID.
+type KeySet interface {
+	Key(ctx context.Context, keyID string) (crypto.PublicKey, error)
+}
+
+type jsonWebKey struct {
+	KeyType string `json:"kty"`
+	KeyID   string `json:"kid"`
+	Use     string `json:"use,omitempty"`
+	N       string `json:"n,omitempty"`
+	E       string `json:"e,omitempty"`
+	Curve   string `json:"crv,omitempty"`
+	X       string `json:"x,omitempty"`
+	Y       string `json:"y,omitempty"`
+}
+
+type jsonWebKeySet struct {
+	Keys []jsonWebKey `json:"keys"`
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+
+	return new(big.Int).SetBytes(b), nil
+}
+
+func (k *jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch k.KeyType {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Curve {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Curve)
+		}
+
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", k.KeyType)
+	}
+}
+
+func decodeKeySet(r io.Reader) (map[string]crypto.PublicKey, error) {
+	keySet := &jsonWebKeySet{}
+	if err := json.NewDecoder(r).Decode(keySet); err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]crypto.PublicKey, len(keySet.Keys))
+	for _, key := range keySet.Keys {
+		if key.Use != "" && key.Use != "sig" {
+			continue
+		}
+
+		publicKey, err := key.publicKey()
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %v", key.KeyID, err)
+		}
+
+		keys[key.KeyID] = publicKey
+	}
+
+	return keys, nil
+}
+
+type staticKeySet map[string]crypto.PublicKey
+
+func (s staticKeySet) Key(ctx context.Context, keyID string) (crypto.PublicKey, error) {
+	key, ok := s[keyID]
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key %q", keyID)
+	}
+
+	return key, nil
+}
+
+// LoadKeySetFile reads a JSON Web Key Set from path, for example to verify
+// tokens in tests without contacting the issuer.
+func LoadKeySetFile(path string) (KeySet, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	defer file.Close()
+	keys, err := decodeKeySet(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	return staticKeySet(keys), nil
+}
+
+// RemoteKeySet fetches a JSON Web Key Set over HTTP and caches it. The set is
+// fetched again after RefreshInterval, or when a token is signed by an
+// unknown key, so that rotated keys are picked up. Fetches run in the
+// background, one at a time and at most once per minute; cached keys keep
+// being served while the set is refreshed or the issuer is unreachable.
+type RemoteKeySet struct {
+	URL             string
+	RefreshInterval time.Duration
+
+	lock        sync.Mutex
+	keys        map[string]crypto.PublicKey
+	fetchedAt   time.Time
+	attemptedAt time.Time
+	fetched     chan struct{}
+	err         error
+}
+
+func NewRemoteKeySet(url string) *RemoteKeySet {
+	return &RemoteKeySet{
+		URL:             url,
+		RefreshInterval: defaultKeySetRefreshInterval,
+	}
+}
+
+func (s *RemoteKeySet) fetch(ctx context.Context) (map[string]crypto.PublicKey, error) {
+	req, err := http.NewRequest("GET", s.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := httpClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code %d", response.StatusCode)
+	}
+
+	return decodeKeySet(response.Body)
+}
+
+// refresh starts fetching the key set unless a fetch is already running and
+// returns a channel closed once it completes. It must be called with s.lock
+// held.
+func (s *RemoteKeySet) refresh() <-chan struct{} {
+	if s.fetched != nil {
+		return s.fetched
+	}
+
+	fetched := make(chan struct{})
+	s.fetched = fetched
+	s.attemptedAt = time.Now()
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), keySetFetchTimeout)
+		defer cancel()
+
+		keys, err := s.fetch(ctx)
+
+		s.lock.Lock()
+		defer s.lock.Unlock()
+
+		if err == nil {
+			s.keys = keys
+			s.fetchedAt = time.Now()
+		}
+
+		s.err = err
+		s.fetched = nil
+		close(fetched)
+	}()
+
+	return fetched
+}
+
+func (s *RemoteKeySet) Key(ctx context.Context, keyID string) (crypto.PublicKey, error) {
+	s.lock.Lock()
+	canFetch := s.fetched != nil || s.attemptedAt.IsZero() || time.Since(s.attemptedAt) > minKeySetRefreshInterval
+	if s.keys != nil && canFetch && time.Since(s.fetchedAt) > s.RefreshInterval {
+		s.refresh()
+	}
+
+	if key, ok := s.keys[keyID]; ok {
+		s.lock.Unlock()
+		return key, nil
+	}
+
+	if !canFetch {
+		err := s.err
+		s.lock.Unlock()
+		if err != nil {
+			return nil, err
+		}
+
+		return nil, fmt.Errorf("unknown signing key %q", keyID)
+	}
+
+	fetched := s.refresh()
+	s.lock.Unlock()
+
+	select {
+	case <-fetched:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if key, ok := s.keys[keyID]; ok {
+		return key, nil
+	}
+
+	if s.err != nil {
+		return nil, s.err
+	}
+
+	return nil, fmt.Errorf("unknown signing key %q", keyID)
+}
@@ -0,0 +1,154 @@
+package auth_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/topos-ai/topos-apis-go/auth"
+	"github.com/topos-ai/topos-apis-go/auth/authtest"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// writeKeySet writes the issuer's JWKS, with extra keys added, to a file in
+// dir and returns its path.
+func writeKeySet(t *testing.T, issuer *authtest.Issuer, dir string, extra ...map[string]string) string {
+	t.Helper()
+
+	response, err := http.Get(issuer.URL + string(authtest.EndpointJWKS))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer response.Body.Close()
+
+	keySet := struct {
+		Keys []map[string]string `json:"keys"`
+	}{}
+
+	if err := json.NewDecoder(response.Body).Decode(&keySet); err != nil {
+		t.Fatal(err)
+	}
+
+	keySet.Keys = append(keySet.Keys, extra...)
+	b, err := json.Marshal(keySet)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(dir, "jwks.json")
+	if err := ioutil.WriteFile(path, b, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+// withHeader replaces the header of token, keeping its payload and
+// signature.
+func withHeader(t *testing.T, token string, header map[string]string) string {
+	t.Helper()
+
+	b, err := json.Marshal(header)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b) + token[strings.Index(token, "."):]
+}
+
+func TestLoadKeySetFile(t *testing.T) {
+	issuer := authtest.NewIssuer()
+	defer issuer.Close()
+
+	dir, err := ioutil.TempDir("", "topos-auth")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keys, err := auth.LoadKeySetFile(writeKeySet(t, issuer, dir, map[string]string{
+		"kty": "EC",
+		"kid": "ec",
+		"use": "sig",
+		"crv": "P-256",
+		"x":   base64.RawURLEncoding.EncodeToString(ecKey.X.Bytes()),
+		"y":   base64.RawURLEncoding.EncodeToString(ecKey.Y.Bytes()),
+	}))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	verifier := auth.NewVerifier(issuer.Config)
+	verifier.Keys = keys
+
+	ctx := context.Background()
+	token := issuer.AccessToken("alice", nil)
+	claims, err := verifier.Verify(ctx, token)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if claims.Subject != "alice" {
+		t.Errorf("subject = %q, want %q", claims.Subject, "alice")
+	}
+
+	headerJSON, err := base64.RawURLEncoding.DecodeString(token[:strings.Index(token, ".")])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	header := map[string]string{}
+	if err := json.Unmarshal(headerJSON, &header); err != nil {
+		t.Fatal(err)
+	}
+
+	other := authtest.NewIssuer()
+	defer other.Close()
+
+	for _, test := range []struct {
+		name  string
+		token string
+		want  string
+	}{{
+		name:  "unknown key ID",
+		token: other.AccessToken("alice", nil),
+		want:  "unknown signing key",
+	}, {
+		name: "ES256 with an RSA key",
+		token: withHeader(t, token, map[string]string{
+			"alg": "ES256",
+			"kid": header["kid"],
+		}),
+		want: "invalid access token signature",
+	}, {
+		name: "RS256 with an EC key",
+		token: withHeader(t, token, map[string]string{
+			"alg": "RS256",
+			"kid": "ec",
+		}),
+		want: "invalid access token signature",
+	}} {
+		_, err := verifier.Verify(ctx, test.token)
+		if status.Code(err) != codes.Unauthenticated || !strings.Contains(status.Convert(err).Message(), test.want) {
+			t.Errorf("%s: err = %v, want %s: %s", test.name, err, codes.Unauthenticated, test.want)
+		}
+	}
+}
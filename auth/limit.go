@@ -0,0 +1,277 @@
+package auth
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Limit caps the rate and concurrency of RPCs.
+type Limit struct {
+	// Rate is the sustained number of RPCs started per second. Zero means
+	// no rate limit.
+	Rate float64
+
+	// Burst is how many RPCs may start at once when the limiter has been
+	// idle. Defaults to 1 when Rate is set.
+	Burst int
+
+	// MaxInFlight caps the number of RPCs running at once, including open
+	// streams. Zero means no cap.
+	MaxInFlight int
+}
+
+// LimiterStats report how much a Limiter throttled RPCs.
+type LimiterStats struct {
+	// Waits is the number of RPCs that waited before starting.
+	Waits int64
+
+	// Canceled is the number of RPCs whose context was done while waiting.
+	Canceled int64
+
+	// WaitTime is the total time RPCs spent waiting.
+	WaitTime time.Duration
+}
+
+// A Limiter enforces a Limit with a token bucket and a cap on in-flight RPCs.
+// It is safe for concurrent use and may be shared by several clients.
+type Limiter struct {
+	limit    Limit
+	inFlight chan struct{}
+
+	lock   sync.Mutex
+	tokens float64
+	last   time.Time
+	stats  LimiterStats
+}
+
+func NewLimiter(limit Limit) *Limiter {
+	if limit.Rate > 0 && limit.Burst < 1 {
+		limit.Burst = 1
+	}
+
+	l := &Limiter{
+		limit:  limit,
+		tokens: float64(limit.Burst),
+		last:   time.Now(),
+	}
+
+	if limit.MaxInFlight > 0 {
+		l.inFlight = make(chan struct{}, limit.MaxInFlight)
+	}
+
+	return l
+}
+
+// Stats returns the throttling the limiter applied so far.
+func (l *Limiter) Stats() LimiterStats {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	return l.stats
+}
+
+func (l *Limiter) record(wait time.Duration, canceled bool) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	l.stats.Waits++
+	l.stats.WaitTime += wait
+	if canceled {
+		l.stats.Canceled++
+	}
+}
+
+// reserve takes a token from the bucket and returns how long to wait until
+// it is available.
+func (l *Limiter) reserve() time.Duration {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	now := time.Now()
+	l.tokens = math.Min(float64(l.limit.Burst), l.tokens+now.Sub(l.last).Seconds()*l.limit.Rate)
+	l.last = now
+	l.tokens--
+	if l.tokens >= 0 {
+		return 0
+	}
+
+	return time.Duration(-l.tokens / l.limit.Rate * float64(time.Second))
+}
+
+func (l *Limiter) unreserve() {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	l.tokens++
+}
+
+// Wait blocks until an RPC may start or ctx is done. On success the returned
+// function must be called once the RPC completes.
+func (l *Limiter) Wait(ctx context.Context) (func(), error) {
+	start := time.Now()
+	waited := false
+
+	if l.limit.Rate > 0 {
+		if wait := l.reserve(); wait > 0 {
+			waited = true
+			timer := time.NewTimer(wait)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				l.unreserve()
+				l.record(time.Since(start), true)
+				return nil, ctx.Err()
+			case <-timer.C:
+			}
+		}
+	}
+
+	if l.inFlight == nil {
+		if waited {
+			l.record(time.Since(start), false)
+		}
+
+		return func() {}, nil
+	}
+
+	select {
+	case l.inFlight <- struct{}{}:
+	default:
+		waited = true
+		select {
+		case l.inFlight <- struct{}{}:
+		case <-ctx.Done():
+			l.record(time.Since(start), true)
+			return nil, ctx.Err()
+		}
+	}
+
+	if waited {
+		l.record(time.Since(start), false)
+	}
+
+	release := &sync.Once{}
+	return func() {
+		release.Do(func() {
+			<-l.inFlight
+		})
+	}, nil
+}
+
+type limiters struct {
+	client  *Limiter
+	methods map[string]*Limiter
+}
+
+func (ls *limiters) empty() bool {
+	return ls.client == nil && len(ls.methods) == 0
+}
+
+func contextStatus(err error) error {
+	if err == context.DeadlineExceeded {
+		return status.Error(codes.DeadlineExceeded, err.Error())
+	}
+
+	return status.Error(codes.Canceled, err.Error())
+}
+
+// wait acquires the method's and the client's limiters. Errors are status
+// errors, as the RPC would have returned.
+func (ls *limiters) wait(ctx context.Context, method string) (func(), error) {
+	var releases []func()
+	release := func() {
+		for _, release := range releases {
+			release()
+		}
+	}
+
+	for _, limiter := range []*Limiter{ls.methods[method], ls.client} {
+		if limiter == nil {
+			continue
+		}
+
+		r, err := limiter.Wait(ctx)
+		if err != nil {
+			release()
+			return nil, contextStatus(err)
+		}
+
+		releases = append(releases, r)
+	}
+
+	return release, nil
+}
+
+func (ls *limiters) unaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		release, err := ls.wait(ctx, method)
+		if err != nil {
+			return err
+		}
+
+		defer release()
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
+
+// limitedClientStream releases its limiters once the stream completes: when
+// RecvMsg fails or returns io.EOF, when the single response of a client
+// stream is received, or when the stream's context is done.
+type limitedClientStream struct {
+	grpc.ClientStream
+	serverStreams bool
+	release       func()
+}
+
+func (s *limitedClientStream) RecvMsg(m interface{}) error {
+	err := s.ClientStream.RecvMsg(m)
+	if err != nil || !s.serverStreams {
+		s.release()
+	}
+
+	return err
+}
+
+func (ls *limiters) streamClientInterceptor() grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		release, err := ls.wait(ctx, method)
+		if err != nil {
+			return nil, err
+		}
+
+		stream, err := streamer(ctx, desc, cc, method, opts...)
+		if err != nil {
+			release()
+			return nil, err
+		}
+
+		once := &sync.Once{}
+		done := make(chan struct{})
+		s := &limitedClientStream{
+			ClientStream:  stream,
+			serverStreams: desc.ServerStreams,
+			release: func() {
+				once.Do(func() {
+					release()
+					close(done)
+				})
+			},
+		}
+
+		go func() {
+			select {
+			case <-stream.Context().Done():
+				s.release()
+			case <-done:
+			}
+		}()
+
+		return s, nil
+	}
+}
@@ -0,0 +1,76 @@
+package auth
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestLimiterMaxInFlight(t *testing.T) {
+	limiter := NewLimiter(Limit{MaxInFlight: 1})
+	release, err := limiter.Wait(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	if _, err := limiter.Wait(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("err = %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	stats := limiter.Stats()
+	if stats.Waits != 1 || stats.Canceled != 1 || stats.WaitTime <= 0 {
+		t.Errorf("stats = %+v, want 1 canceled wait", stats)
+	}
+
+	// Releasing twice frees a single slot.
+	release()
+	release()
+	if _, err := limiter.Wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if inFlight := len(limiter.inFlight); inFlight != 1 {
+		t.Errorf("%d slots held, want 1", inFlight)
+	}
+}
+
+func TestLimiterRateCancel(t *testing.T) {
+	limiter := NewLimiter(Limit{Rate: 1})
+	if _, err := limiter.Wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	if _, err := limiter.Wait(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("err = %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	stats := limiter.Stats()
+	if stats.Waits != 1 || stats.Canceled != 1 {
+		t.Errorf("stats = %+v, want 1 canceled wait", stats)
+	}
+
+	// The canceled wait gave its token back, so the next RPC waits for one
+	// token rather than two.
+	if wait := limiter.reserve(); wait > time.Second {
+		t.Errorf("wait = %v, want at most 1s", wait)
+	}
+}
+
+func TestLimiterBurst(t *testing.T) {
+	limiter := NewLimiter(Limit{Rate: 1, Burst: 3})
+	for i := 0; i < 3; i++ {
+		if _, err := limiter.Wait(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if stats := limiter.Stats(); stats.Waits != 0 {
+		t.Errorf("stats = %+v, want no waits within the burst", stats)
+	}
+}
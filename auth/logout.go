@@ -0,0 +1,84 @@
+package auth
+
+import (
+	"context"
+	"net/url"
+)
+
+type logoutOptions struct {
+	config  *Config
+	store   TokenStore
+	profile string
+}
+
+type LogoutOption func(*logoutOptions)
+
+// LogoutWithProfile ends the session of the named profile. Defaults to
+// TOPOS_PROFILE or DefaultProfile.
+func LogoutWithProfile(profile string) LogoutOption {
+	return func(options *logoutOptions) {
+		options.profile = profile
+	}
+}
+
+// LogoutWithConfig sets the OAuth environment the refresh token is revoked
+// against. Defaults to the profile's configuration, see LoadProfileConfig.
+func LogoutWithConfig(config *Config) LogoutOption {
+	return func(options *logoutOptions) {
+		options.config = config
+	}
+}
+
+// LogoutWithTokenStore sets where the tokens to remove are kept. Defaults to
+// the config's token store.
+func LogoutWithTokenStore(store TokenStore) LogoutOption {
+	return func(options *logoutOptions) {
+		options.store = store
+	}
+}
+
+func (c *Config) revokeToken(ctx context.Context, token string) error {
+	payload := url.Values{}
+	payload.Set("client_id", c.ClientID)
+	payload.Set("token", token)
+	payload.Set("token_type_hint", "refresh_token")
+
+	return postForm(ctx, c.revocationURL(), payload, nil)
+}
+
+// Logout revokes the stored refresh token with the issuer and deletes the
+// stored access and refresh tokens. The tokens are deleted even if revocation
+// fails; revoked reports whether the issuer confirmed the revocation, and err
+// holds the revocation error if it did not. Logout returns false and a nil
+// error if no refresh token was stored.
+func Logout(ctx context.Context, opts ...LogoutOption) (revoked bool, err error) {
+	options := &logoutOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	config, store, err := loadProfile(options.profile, options.config, options.store)
+	if err != nil {
+		return false, err
+	}
+
+	var revokeErr error
+	if refreshToken, err := store.Token(refreshTokenKey); err != nil {
+		if err != ErrTokenNotFound {
+			return false, err
+		}
+	} else {
+		revokeErr = config.revokeToken(ctx, refreshToken)
+		revoked = revokeErr == nil
+	}
+
+	if err := store.DeleteToken(accessTokenKey); err != nil {
+		return revoked, err
+	}
+
+	if err := store.DeleteToken(refreshTokenKey); err != nil {
+		return revoked, err
+	}
+
+	return revoked, revokeErr
+}
@@ -0,0 +1,82 @@
+package auth
+
+import (
+	"context"
+	"time"
+
+	"golang.org/x/oauth2"
+	"google.golang.org/grpc/credentials"
+)
+
+type credentialsTokenSource struct {
+	creds credentials.PerRPCCredentials
+}
+
+// NewTokenSource returns an oauth2.TokenSource yielding the access tokens
+// creds attach to RPCs, for example to authenticate HTTP requests with the
+// credentials returned by Credentials. Forwarding credentials yield
+// ErrNoAccessToken as there is no incoming request.
+func NewTokenSource(creds credentials.PerRPCCredentials) oauth2.TokenSource {
+	return &credentialsTokenSource{creds: creds}
+}
+
+// TokenSource returns an oauth2.TokenSource for the credentials Dial would
+// use with the same arguments.
+func TokenSource(useLocalCredentials bool, opts ...DialOption) (oauth2.TokenSource, error) {
+	creds, err := Credentials(useLocalCredentials, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewTokenSource(creds), nil
+}
+
+func (s *credentialsTokenSource) Token() (*oauth2.Token, error) {
+	requestMetadata, err := s.creds.GetRequestMetadata(context.Background())
+	if err != nil {
+		return nil, err
+	}
+
+	accessToken := bearerToken(requestMetadata["authorization"])
+	if accessToken == "" {
+		return nil, ErrNoAccessToken
+	}
+
+	token := &oauth2.Token{
+		AccessToken: accessToken,
+		TokenType:   "Bearer",
+	}
+
+	if claims, err := ParseUnverifiedClaims(accessToken); err == nil && claims.ExpiresAt != 0 {
+		token.Expiry = time.Unix(claims.ExpiresAt, 0)
+	}
+
+	return token, nil
+}
+
+type tokenSourceCredentials struct {
+	source oauth2.TokenSource
+}
+
+// NewTokenSourceCredentials returns per-RPC credentials sending the access
+// tokens of source, which is wrapped with oauth2.ReuseTokenSource.
+func NewTokenSourceCredentials(source oauth2.TokenSource) credentials.PerRPCCredentials {
+	return &tokenSourceCredentials{
+		source: oauth2.ReuseTokenSource(nil, source),
+	}
+}
+
+func (c *tokenSourceCredentials) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	token, err := c.source.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]string{
+		"authorization": token.AccessToken,
+	}, nil
+}
+
+func (*tokenSourceCredentials) RequireTransportSecurity() bool {
+	return true
+}
@@ -0,0 +1,185 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"html/template"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+const callbackShutdownTimeout = 5 * time.Second
+
+var callbackPage = template.Must(template.New("callback").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Topos - {{.Title}}</title>
+<style>body{font-family:sans-serif;margin:4em auto;max-width:32em;color:#222}</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<p>{{.Message}}</p>
+</body>
+</html>
+`))
+
+type callbackPageData struct {
+	Title   string
+	Message string
+}
+
+func renderCallbackPage(w http.ResponseWriter, status int, title, message string) {
+	w.Header().Set("content-type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	callbackPage.Execute(w, callbackPageData{
+		Title:   title,
+		Message: message,
+	})
+}
+
+type callbackResult struct {
+	code string
+	err  error
+}
+
+func randomString() (string, error) {
+	value := make([]byte, 32)
+	if _, err := rand.Read(value); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(value), nil
+}
+
+func sha256SumString(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// listenCallback listens on the loopback interface on port, or on any free
+// port if port is taken or zero.
+func listenCallback(port int) (net.Listener, error) {
+	listener, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+	if err == nil || port == 0 {
+		return listener, err
+	}
+
+	return net.Listen("tcp", "127.0.0.1:0")
+}
+
+func callbackHandler(state string, resultCh chan<- callbackResult) http.Handler {
+	done := &sync.Once{}
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/callback" {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+
+		if reqState := req.FormValue("state"); reqState != state {
+			renderCallbackPage(w, http.StatusUnauthorized, "Login failed", "The login request did not match. Start the login again from the command line.")
+			return
+		}
+
+		handled := false
+		done.Do(func() {
+			handled = true
+			if code := req.FormValue("error"); code != "" {
+				err := &TokenError{
+					StatusCode:  http.StatusUnauthorized,
+					Code:        code,
+					Description: req.FormValue("error_description"),
+				}
+
+				renderCallbackPage(w, http.StatusUnauthorized, "Login failed", err.Error())
+				resultCh <- callbackResult{err: err}
+				return
+			}
+
+			code := req.FormValue("code")
+			if code == "" {
+				renderCallbackPage(w, http.StatusBadRequest, "Login failed", "No authorization code was received.")
+				resultCh <- callbackResult{err: fmt.Errorf("callback without authorization code")}
+				return
+			}
+
+			renderCallbackPage(w, http.StatusOK, "Logged in", "You can close this window and return to the command line.")
+			resultCh <- callbackResult{code: code}
+		})
+
+		if !handled {
+			renderCallbackPage(w, http.StatusOK, "Login complete", "This login has already been completed. You can close this window.")
+		}
+	})
+}
+
+func (c *Config) getTokenPKCE(ctx context.Context, openBrowser BrowserOpener) (*getTokenResponse, error) {
+	codeVerifier, err := randomString()
+	if err != nil {
+		return nil, err
+	}
+
+	state, err := randomString()
+	if err != nil {
+		return nil, err
+	}
+
+	listener, err := listenCallback(c.CallbackPort)
+	if err != nil {
+		return nil, err
+	}
+
+	redirectURI := callbackURL(listener.Addr().(*net.TCPAddr).Port)
+	resultCh := make(chan callbackResult, 1)
+	server := &http.Server{
+		Handler: callbackHandler(state, resultCh),
+	}
+
+	go server.Serve(listener)
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), callbackShutdownTimeout)
+		defer cancel()
+		server.Shutdown(ctx)
+	}()
+
+	authorizePayload := url.Values{}
+	authorizePayload.Set("audience", c.Audience)
+	authorizePayload.Set("client_id", c.ClientID)
+	authorizePayload.Set("code_challenge", sha256SumString(codeVerifier))
+	authorizePayload.Set("code_challenge_method", "S256")
+	authorizePayload.Set("redirect_uri", redirectURI)
+	authorizePayload.Set("response_type", "code")
+	authorizePayload.Set("state", state)
+
+	authorizePayload.Add("scope", "offline_access")
+
+	if err := openBrowser(c.authorizeURL() + "?" + authorizePayload.Encode()); err != nil {
+		return nil, err
+	}
+
+	var result callbackResult
+	select {
+	case result = <-resultCh:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	if result.err != nil {
+		return nil, result.err
+	}
+
+	tokenPayload := url.Values{}
+	tokenPayload.Set("client_id", c.ClientID)
+	tokenPayload.Set("code", result.code)
+	tokenPayload.Set("code_verifier", codeVerifier)
+	tokenPayload.Set("grant_type", "authorization_code")
+	tokenPayload.Set("redirect_uri", redirectURI)
+
+	return c.getToken(ctx, tokenPayload)
+}
@@ -0,0 +1,97 @@
+package auth
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// DefaultProfile is the profile whose tokens live directly in ~/.topos.
+const DefaultProfile string = "default"
+
+func activeProfile(profile string) string {
+	if profile == "" {
+		profile = os.Getenv("TOPOS_PROFILE")
+	}
+
+	if profile == "" {
+		return DefaultProfile
+	}
+
+	return profile
+}
+
+func validateProfile(profile string) error {
+	if profile == "" || profile == "." || profile == ".." || strings.ContainsAny(profile, `/\`) {
+		return fmt.Errorf("invalid profile name %q", profile)
+	}
+
+	return nil
+}
+
+func profileDirectory(profile string) (string, error) {
+	toposDir, err := toposDirectory()
+	if err != nil {
+		return "", err
+	}
+
+	if profile == DefaultProfile {
+		return toposDir, nil
+	}
+
+	if err := validateProfile(profile); err != nil {
+		return "", err
+	}
+
+	return filepath.Join(toposDir, "profiles", profile), nil
+}
+
+// Profiles returns the names of the profiles that have been logged in to,
+// including DefaultProfile.
+func Profiles() ([]string, error) {
+	toposDir, err := toposDirectory()
+	if err != nil {
+		return nil, err
+	}
+
+	profiles := []string{DefaultProfile}
+	infos, err := ioutil.ReadDir(filepath.Join(toposDir, "profiles"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return profiles, nil
+		}
+
+		return nil, err
+	}
+
+	for _, info := range infos {
+		if info.IsDir() && info.Name() != DefaultProfile {
+			profiles = append(profiles, info.Name())
+		}
+	}
+
+	return profiles, nil
+}
+
+func loadProfile(profile string, config *Config, store TokenStore) (*Config, TokenStore, error) {
+	profile = activeProfile(profile)
+	if config == nil {
+		var err error
+		config, err = LoadProfileConfig(profile)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if store == nil {
+		var err error
+		store, err = config.tokenStore(profile)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return config, store, nil
+}
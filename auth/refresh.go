@@ -0,0 +1,195 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	refreshTimeout = time.Minute
+
+	// After a background refresh fails, the next one waits for a backoff
+	// doubling from minRefreshBackoff up to maxRefreshBackoff.
+	minRefreshBackoff = time.Second
+	maxRefreshBackoff = time.Minute
+)
+
+var errExpiredAccessToken = errors.New("refreshed access token is already expired")
+
+// RefreshPolicy controls when cached access tokens are refreshed.
+type RefreshPolicy struct {
+	// Margin is how long before expiry a background refresh starts. Until
+	// the token actually expires RPCs keep using the cached token.
+	Margin time.Duration
+
+	// ClockSkew is subtracted from the expiry of every token, so that a
+	// local clock running behind the issuer's does not send expired tokens.
+	ClockSkew time.Duration
+}
+
+// DefaultRefreshPolicy starts refreshing tokens a minute before they expire.
+var DefaultRefreshPolicy = RefreshPolicy{
+	Margin:    time.Minute,
+	ClockSkew: 10 * time.Second,
+}
+
+// A RefreshObserver is called after every token refresh with its latency and
+// error, if any, for example to record metrics.
+type RefreshObserver func(latency time.Duration, err error)
+
+// tokenFetcher obtains a new access token and its expiry in Unix seconds. A
+// fetcher backed by shared storage may return a stored token that is valid
+// until at least validUntil instead of contacting the issuer.
+type tokenFetcher func(ctx context.Context, validUntil int64) (string, int64, error)
+
+// tokenCache caches an access token, refreshing it in the background once it
+// enters the policy's margin. At most one refresh runs at a time; callers
+// only wait for it once the cached token has expired. Failed background
+// refreshes are retried with exponential backoff.
+type tokenCache struct {
+	lock        sync.Mutex
+	policy      RefreshPolicy
+	observe     RefreshObserver
+	fetch       tokenFetcher
+	accessToken string
+	expiry      int64
+	refreshed   chan struct{}
+	err         error
+
+	// retryAt is when the next background refresh may start after backoff,
+	// following failed refreshes.
+	retryAt time.Time
+	backoff time.Duration
+
+	// force makes the next refresh bypass tokens shared through storage,
+	// after the cached token was rejected.
+	force bool
+}
+
+func newTokenCache(policy RefreshPolicy, observe RefreshObserver, fetch tokenFetcher) *tokenCache {
+	return &tokenCache{
+		policy:  policy,
+		observe: observe,
+		fetch:   fetch,
+	}
+}
+
+func (c *tokenCache) set(accessToken string, expiry int64) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.accessToken = accessToken
+	c.expiry = expiry
+}
+
+func (c *tokenCache) validUntil() time.Time {
+	return time.Unix(c.expiry, 0).Add(-c.policy.ClockSkew)
+}
+
+// refresh starts a refresh unless one is already running and returns a
+// channel closed once it completes. It must be called with c.lock held.
+func (c *tokenCache) refresh() <-chan struct{} {
+	if c.refreshed != nil {
+		return c.refreshed
+	}
+
+	refreshed := make(chan struct{})
+	c.refreshed = refreshed
+	validUntil := time.Now().Add(c.policy.Margin + c.policy.ClockSkew).Unix()
+	if c.force {
+		validUntil = math.MaxInt64
+		c.force = false
+	}
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), refreshTimeout)
+		defer cancel()
+
+		start := time.Now()
+		accessToken, expiry, err := c.fetch(ctx, validUntil)
+		latency := time.Since(start)
+		if c.observe != nil {
+			c.observe(latency, err)
+		}
+
+		c.lock.Lock()
+		defer c.lock.Unlock()
+
+		if err == nil {
+			c.accessToken = accessToken
+			c.expiry = expiry
+			c.retryAt = time.Time{}
+			c.backoff = 0
+		} else {
+			c.backoff *= 2
+			if c.backoff < minRefreshBackoff {
+				c.backoff = minRefreshBackoff
+			} else if c.backoff > maxRefreshBackoff {
+				c.backoff = maxRefreshBackoff
+			}
+
+			c.retryAt = time.Now().Add(c.backoff)
+		}
+
+		c.err = err
+		c.refreshed = nil
+		close(refreshed)
+	}()
+
+	return refreshed
+}
+
+// invalidate drops accessToken if it is still the cached token, for example
+// after a server rejected it, so that the next call to token fetches a new
+// one.
+func (c *tokenCache) invalidate(accessToken string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if accessToken == "" || c.accessToken != accessToken {
+		return
+	}
+
+	c.accessToken = ""
+	c.expiry = 0
+	c.force = true
+}
+
+func (c *tokenCache) token(ctx context.Context) (string, error) {
+	c.lock.Lock()
+	now := time.Now()
+	if validUntil := c.validUntil(); c.accessToken != "" && now.Before(validUntil) {
+		if !now.Before(validUntil.Add(-c.policy.Margin)) && !now.Before(c.retryAt) {
+			c.refresh()
+		}
+
+		accessToken := c.accessToken
+		c.lock.Unlock()
+		return accessToken, nil
+	}
+
+	refreshed := c.refresh()
+	c.lock.Unlock()
+
+	select {
+	case <-refreshed:
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.accessToken != "" && time.Now().Before(c.validUntil()) {
+		return c.accessToken, nil
+	}
+
+	if c.err != nil {
+		return "", c.err
+	}
+
+	return "", errExpiredAccessToken
+}
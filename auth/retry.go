@@ -0,0 +1,286 @@
+package auth
+
+import (
+	"context"
+	"io"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// retryPushbackKey is the trailer a server sets to tell clients when to retry,
+// in milliseconds. A negative value asks clients not to retry.
+const retryPushbackKey = "grpc-retry-pushback-ms"
+
+// RetryPolicy controls how failed RPCs are retried by clients dialed with
+// Dial. Only RPCs classified as idempotent are retried: unary RPCs, and client
+// streaming RPCs such as PolygonSearchPoints, whose requests are replayed on
+// a new stream. Server streaming RPCs are not retried.
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts, including the first. One
+	// or less disables retries.
+	MaxAttempts int
+
+	// InitialBackoff is the wait before the first retry. Each following
+	// wait is multiplied by Multiplier, up to MaxBackoff. Waits are jittered
+	// by up to half their length either way.
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	Multiplier     float64
+
+	// RetryableCodes are the status codes that are retried.
+	RetryableCodes []codes.Code
+
+	// Idempotent reports whether the full gRPC method name, such as
+	// /topos.locations.v1.Locations/GetRegion, may safely be retried.
+	// Defaults to IdempotentMethod.
+	Idempotent func(method string) bool
+}
+
+// DefaultRetryPolicy retries idempotent RPCs failing with Unavailable or
+// ResourceExhausted up to 4 attempts.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:    4,
+	InitialBackoff: 100 * time.Millisecond,
+	MaxBackoff:     5 * time.Second,
+	Multiplier:     2,
+	RetryableCodes: []codes.Code{codes.Unavailable, codes.ResourceExhausted},
+}
+
+var idempotentMethodPrefixes = []string{
+	"Get",
+	"List",
+	"Search",
+	"RadiusSearch",
+	"Locate",
+	"Count",
+	"Top",
+	"Polygon",
+	"Intersect",
+}
+
+// IdempotentMethod classifies the read-only methods of the Topos services,
+// such as GetRegion, SearchPoints, PolygonSearchPoints or TopGraphScores, as
+// idempotent.
+func IdempotentMethod(method string) bool {
+	name := method[strings.LastIndex(method, "/")+1:]
+	for _, prefix := range idempotentMethodPrefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (p *RetryPolicy) idempotent(method string) bool {
+	if p.Idempotent == nil {
+		return IdempotentMethod(method)
+	}
+
+	return p.Idempotent(method)
+}
+
+func (p *RetryPolicy) retryable(err error) bool {
+	code := status.Code(err)
+	for _, retryableCode := range p.RetryableCodes {
+		if code == retryableCode {
+			return true
+		}
+	}
+
+	return false
+}
+
+// pushback returns the wait requested by the server in trailer, and false if
+// the server asked not to retry.
+func pushback(trailer metadata.MD) (time.Duration, bool) {
+	values := trailer.Get(retryPushbackKey)
+	if len(values) == 0 {
+		return 0, true
+	}
+
+	ms, err := strconv.Atoi(values[0])
+	if err != nil || ms < 0 {
+		return 0, false
+	}
+
+	return time.Duration(ms) * time.Millisecond, true
+}
+
+// wait sleeps before retrying the attempt that failed with err, and returns
+// the backoff of the next retry. It returns false if the RPC must not be
+// retried.
+func (p *RetryPolicy) wait(ctx context.Context, attempt int, backoff time.Duration, err error, trailer metadata.MD) (time.Duration, bool) {
+	if attempt >= p.MaxAttempts || !p.retryable(err) || ctx.Err() != nil {
+		return 0, false
+	}
+
+	wait, ok := pushback(trailer)
+	if !ok {
+		return 0, false
+	}
+
+	if wait == 0 && backoff > 0 {
+		wait = backoff/2 + time.Duration(rand.Int63n(int64(backoff)))
+	}
+
+	timer := time.NewTimer(wait)
+	select {
+	case <-ctx.Done():
+		timer.Stop()
+		return 0, false
+	case <-timer.C:
+	}
+
+	backoff = time.Duration(float64(backoff) * p.Multiplier)
+	if p.MaxBackoff > 0 && backoff > p.MaxBackoff {
+		backoff = p.MaxBackoff
+	}
+
+	return backoff, true
+}
+
+// UnaryClientInterceptor retries failed idempotent unary RPCs according to
+// the policy. Retries stop when the context is done. Iterators fetch pages
+// with unary RPCs, so a failed page is retried from its own page token.
+func (p RetryPolicy) UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		if p.MaxAttempts <= 1 || !p.idempotent(method) {
+			return invoker(ctx, method, req, reply, cc, opts...)
+		}
+
+		backoff := p.InitialBackoff
+		for attempt := 1; ; attempt++ {
+			var trailer metadata.MD
+			err := invoker(ctx, method, req, reply, cc, append(opts[:len(opts):len(opts)], grpc.Trailer(&trailer))...)
+			if err == nil {
+				return nil
+			}
+
+			var ok bool
+			if backoff, ok = p.wait(ctx, attempt, backoff, err, trailer); !ok {
+				return err
+			}
+		}
+	}
+}
+
+// retryingClientStream keeps the requests sent on a client streaming RPC, so
+// that they can be replayed on a new stream if the response fails.
+type retryingClientStream struct {
+	grpc.ClientStream
+	policy *RetryPolicy
+	ctx    context.Context
+	open   func() (grpc.ClientStream, error)
+
+	requests []proto.Message
+	closed   bool
+
+	// err is the error of a failed send, reported by RecvMsg.
+	err error
+}
+
+// SendMsg sends m and keeps a copy, as callers may reuse m. A failed send is
+// not reported until RecvMsg, which retries the RPC.
+func (s *retryingClientStream) SendMsg(m interface{}) error {
+	message, ok := m.(proto.Message)
+	if !ok {
+		return s.ClientStream.SendMsg(m)
+	}
+
+	s.requests = append(s.requests, proto.Clone(message))
+	if s.err == nil {
+		if err := s.ClientStream.SendMsg(m); err != nil {
+			s.err = err
+		}
+	}
+
+	return nil
+}
+
+func (s *retryingClientStream) CloseSend() error {
+	s.closed = true
+	if s.err != nil {
+		return nil
+	}
+
+	return s.ClientStream.CloseSend()
+}
+
+// replay opens a new stream and sends the requests sent so far.
+func (s *retryingClientStream) replay() error {
+	stream, err := s.open()
+	if err != nil {
+		return err
+	}
+
+	s.ClientStream = stream
+	s.err = nil
+	for _, request := range s.requests {
+		if err := stream.SendMsg(request); err != nil {
+			s.err = err
+			return nil
+		}
+	}
+
+	if s.closed {
+		return stream.CloseSend()
+	}
+
+	return nil
+}
+
+func (s *retryingClientStream) RecvMsg(m interface{}) error {
+	backoff := s.policy.InitialBackoff
+	for attempt := 1; ; attempt++ {
+		err := s.ClientStream.RecvMsg(m)
+		if err == nil || err == io.EOF {
+			return err
+		}
+
+		var ok bool
+		if backoff, ok = s.policy.wait(s.ctx, attempt, backoff, err, s.ClientStream.Trailer()); !ok {
+			return err
+		}
+
+		if replayErr := s.replay(); replayErr != nil {
+			return replayErr
+		}
+	}
+}
+
+// StreamClientInterceptor retries failed idempotent client streaming RPCs
+// according to the policy, replaying their requests on a new stream. Such
+// RPCs, like the page fetches of PolygonSearchPoints, are retried once their
+// response fails, so the requests are kept until then.
+func (p RetryPolicy) StreamClientInterceptor() grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		if p.MaxAttempts <= 1 || desc.ServerStreams || !desc.ClientStreams || !p.idempotent(method) {
+			return streamer(ctx, desc, cc, method, opts...)
+		}
+
+		open := func() (grpc.ClientStream, error) {
+			return streamer(ctx, desc, cc, method, opts...)
+		}
+
+		stream, err := open()
+		if err != nil {
+			return nil, err
+		}
+
+		return &retryingClientStream{
+			ClientStream: stream,
+			policy:       &p,
+			ctx:          ctx,
+			open:         open,
+		}, nil
+	}
+}
@@ -0,0 +1,249 @@
+package auth
+
+import (
+	"context"
+	"io"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/ptypes/wrappers"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// fakeClientStream is a client stream whose sends fail after sendLimit
+// messages, if set, and whose response is reply, or recvErr if set.
+type fakeClientStream struct {
+	ctx       context.Context
+	sendLimit int
+	recvErr   error
+	trailer   metadata.MD
+	reply     string
+
+	sent   []string
+	closed bool
+}
+
+func (s *fakeClientStream) Header() (metadata.MD, error) {
+	return nil, nil
+}
+
+func (s *fakeClientStream) Trailer() metadata.MD {
+	return s.trailer
+}
+
+func (s *fakeClientStream) CloseSend() error {
+	s.closed = true
+	return nil
+}
+
+func (s *fakeClientStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *fakeClientStream) SendMsg(m interface{}) error {
+	if s.closed {
+		return io.EOF
+	}
+
+	if s.sendLimit > 0 && len(s.sent) == s.sendLimit {
+		return io.EOF
+	}
+
+	s.sent = append(s.sent, m.(*wrappers.StringValue).Value)
+	return nil
+}
+
+func (s *fakeClientStream) RecvMsg(m interface{}) error {
+	if s.recvErr != nil {
+		return s.recvErr
+	}
+
+	m.(*wrappers.StringValue).Value = s.reply
+	return nil
+}
+
+// fakeStreamer opens streams in order, one per attempt.
+type fakeStreamer struct {
+	streams []*fakeClientStream
+	opened  int
+}
+
+func (f *fakeStreamer) streamer(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	stream := f.streams[f.opened]
+	stream.ctx = ctx
+	f.opened++
+	return stream, nil
+}
+
+var (
+	clientStreamDesc = &grpc.StreamDesc{ClientStreams: true}
+	testRetryPolicy  = RetryPolicy{
+		MaxAttempts:    3,
+		InitialBackoff: time.Millisecond,
+		MaxBackoff:     time.Millisecond,
+		Multiplier:     2,
+		RetryableCodes: []codes.Code{codes.Unavailable},
+	}
+)
+
+const testStreamMethod = "/topos.points.v1.Points/PolygonSearchPoints"
+
+// callClientStream sends requests on a client stream opened through
+// interceptor and returns its response.
+func callClientStream(ctx context.Context, interceptor grpc.StreamClientInterceptor, streamer grpc.Streamer, requests ...string) (string, error) {
+	stream, err := interceptor(ctx, clientStreamDesc, nil, testStreamMethod, streamer)
+	if err != nil {
+		return "", err
+	}
+
+	// Callers may reuse the request message between sends.
+	request := &wrappers.StringValue{}
+	for _, value := range requests {
+		request.Value = value
+		if err := stream.SendMsg(request); err != nil {
+			return "", err
+		}
+	}
+
+	if err := stream.CloseSend(); err != nil {
+		return "", err
+	}
+
+	reply := &wrappers.StringValue{}
+	if err := stream.RecvMsg(reply); err != nil {
+		return "", err
+	}
+
+	return reply.Value, nil
+}
+
+func TestRetryClientStreamReplay(t *testing.T) {
+	fake := &fakeStreamer{streams: []*fakeClientStream{
+		{recvErr: status.Error(codes.Unavailable, "unavailable")},
+		{reply: "ok"},
+	}}
+
+	reply, err := callClientStream(context.Background(), testRetryPolicy.StreamClientInterceptor(), fake.streamer, "a", "b", "c")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if reply != "ok" {
+		t.Errorf("reply = %q, want %q", reply, "ok")
+	}
+
+	if fake.opened != 2 {
+		t.Fatalf("opened %d streams, want 2", fake.opened)
+	}
+
+	want := []string{"a", "b", "c"}
+	for i, stream := range fake.streams {
+		if !reflect.DeepEqual(stream.sent, want) {
+			t.Errorf("stream %d sent %q, want %q", i, stream.sent, want)
+		}
+
+		if !stream.closed {
+			t.Errorf("stream %d was not half-closed", i)
+		}
+	}
+}
+
+func TestRetryClientStreamSendFailure(t *testing.T) {
+	fake := &fakeStreamer{streams: []*fakeClientStream{
+		{sendLimit: 1, recvErr: status.Error(codes.Unavailable, "unavailable")},
+		{reply: "ok"},
+	}}
+
+	reply, err := callClientStream(context.Background(), testRetryPolicy.StreamClientInterceptor(), fake.streamer, "a", "b", "c")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if reply != "ok" {
+		t.Errorf("reply = %q, want %q", reply, "ok")
+	}
+
+	if sent := fake.streams[0].sent; !reflect.DeepEqual(sent, []string{"a"}) {
+		t.Errorf("failed stream sent %q, want %q", sent, []string{"a"})
+	}
+
+	if sent := fake.streams[1].sent; !reflect.DeepEqual(sent, []string{"a", "b", "c"}) {
+		t.Errorf("retried stream sent %q, want %q", sent, []string{"a", "b", "c"})
+	}
+
+	if !fake.streams[1].closed {
+		t.Error("retried stream was not half-closed")
+	}
+}
+
+func TestRetryClientStreamPushback(t *testing.T) {
+	unavailable := status.Error(codes.Unavailable, "unavailable")
+	fake := &fakeStreamer{streams: []*fakeClientStream{
+		{recvErr: unavailable, trailer: metadata.Pairs(retryPushbackKey, "-1")},
+		{reply: "ok"},
+	}}
+
+	if _, err := callClientStream(context.Background(), testRetryPolicy.StreamClientInterceptor(), fake.streamer, "a"); err != unavailable {
+		t.Fatalf("err = %v, want %v", err, unavailable)
+	}
+
+	if fake.opened != 1 {
+		t.Errorf("opened %d streams, want 1", fake.opened)
+	}
+}
+
+func TestRetryClientStreamAttempts(t *testing.T) {
+	unavailable := status.Error(codes.Unavailable, "unavailable")
+	fake := &fakeStreamer{streams: []*fakeClientStream{
+		{recvErr: unavailable},
+		{recvErr: unavailable},
+		{recvErr: unavailable},
+		{reply: "ok"},
+	}}
+
+	if _, err := callClientStream(context.Background(), testRetryPolicy.StreamClientInterceptor(), fake.streamer, "a"); err != unavailable {
+		t.Fatalf("err = %v, want %v", err, unavailable)
+	}
+
+	if fake.opened != testRetryPolicy.MaxAttempts {
+		t.Errorf("opened %d streams, want %d", fake.opened, testRetryPolicy.MaxAttempts)
+	}
+}
+
+func TestRetryClientStreamLimiter(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	limiter := NewLimiter(Limit{MaxInFlight: 1})
+	ls := &limiters{client: limiter}
+	limit := ls.streamClientInterceptor()
+
+	fake := &fakeStreamer{streams: []*fakeClientStream{
+		{recvErr: status.Error(codes.Unavailable, "unavailable")},
+		{recvErr: status.Error(codes.Unavailable, "unavailable")},
+		{reply: "ok"},
+	}}
+
+	// The limiter runs inside the retries, as Dial chains them, so each
+	// attempt must release its slot for the next one to start.
+	streamer := func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		return limit(ctx, desc, cc, method, fake.streamer, opts...)
+	}
+
+	reply, err := callClientStream(ctx, testRetryPolicy.StreamClientInterceptor(), streamer, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if reply != "ok" {
+		t.Errorf("reply = %q, want %q", reply, "ok")
+	}
+
+	if inFlight := len(limiter.inFlight); inFlight != 0 {
+		t.Errorf("%d limiter slots held after the RPC completed, want 0", inFlight)
+	}
+}
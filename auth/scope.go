@@ -0,0 +1,137 @@
+package auth
+
+import (
+	"context"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// TokenScope is the audience and scopes an access token is requested for.
+// The zero TokenScope requests the config's audience and the scopes the
+// issuer grants by default.
+type TokenScope struct {
+	Audience string
+	Scopes   []string
+}
+
+func (s TokenScope) isZero() bool {
+	return s.Audience == "" && len(s.Scopes) == 0
+}
+
+func (s TokenScope) key() string {
+	scopes := append([]string(nil), s.Scopes...)
+	sort.Strings(scopes)
+	return s.Audience + " " + strings.Join(scopes, " ")
+}
+
+func (s TokenScope) setPayload(payload url.Values) {
+	if s.Audience != "" {
+		payload.Set("audience", s.Audience)
+	}
+
+	if len(s.Scopes) > 0 {
+		payload.Set("scope", strings.Join(s.Scopes, " "))
+	}
+}
+
+// A ScopeResolver returns the scope of the access token to send with an RPC
+// to uri, which is of the form https://host/package.Service. uri is empty
+// when the token is requested outside of an RPC, for example by WhoAmI.
+type ScopeResolver func(uri string) TokenScope
+
+// StaticTokenScope returns a ScopeResolver always resolving to scope.
+func StaticTokenScope(scope TokenScope) ScopeResolver {
+	return func(uri string) TokenScope {
+		return scope
+	}
+}
+
+// AudienceFromURI is a ScopeResolver using the scheme and host of the RPC
+// URI, such as https://locations.topos.com, as audience.
+func AudienceFromURI(uri string) TokenScope {
+	u, err := url.Parse(uri)
+	if err != nil || u.Host == "" {
+		return TokenScope{}
+	}
+
+	return TokenScope{
+		Audience: u.Scheme + "://" + u.Hostname(),
+	}
+}
+
+type scopedTokenFetcher func(ctx context.Context, scope TokenScope) (string, int64, error)
+
+// scopedTokenCache keeps a tokenCache per TokenScope, so that tokens for
+// different audiences and scopes are requested and refreshed independently.
+type scopedTokenCache struct {
+	policy  RefreshPolicy
+	observe RefreshObserver
+	resolve ScopeResolver
+	fetch   scopedTokenFetcher
+
+	lock   sync.Mutex
+	caches map[string]*tokenCache
+}
+
+func newScopedTokenCache(policy RefreshPolicy, observe RefreshObserver, resolve ScopeResolver, fetch scopedTokenFetcher) *scopedTokenCache {
+	return &scopedTokenCache{
+		policy:  policy,
+		observe: observe,
+		resolve: resolve,
+		fetch:   fetch,
+		caches:  map[string]*tokenCache{},
+	}
+}
+
+func (c *scopedTokenCache) cache(scope TokenScope) *tokenCache {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	key := scope.key()
+	cache, ok := c.caches[key]
+	if !ok {
+		cache = newTokenCache(c.policy, c.observe, func(ctx context.Context, validUntil int64) (string, int64, error) {
+			return c.fetch(ctx, scope)
+		})
+
+		c.caches[key] = cache
+	}
+
+	return cache
+}
+
+// token returns an access token for the scope resolved from uri, or from
+// defaultCache if c is nil or the scope is zero.
+func (c *scopedTokenCache) token(ctx context.Context, defaultCache *tokenCache, uri []string) (string, error) {
+	if c == nil {
+		return defaultCache.token(ctx)
+	}
+
+	var target string
+	if len(uri) > 0 {
+		target = uri[0]
+	}
+
+	scope := c.resolve(target)
+	if scope.isZero() {
+		return defaultCache.token(ctx)
+	}
+
+	return c.cache(scope).token(ctx)
+}
+
+func (c *scopedTokenCache) invalidate(defaultCache *tokenCache, accessToken string) {
+	defaultCache.invalidate(accessToken)
+	if c == nil {
+		return
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	for _, cache := range c.caches {
+		cache.invalidate(accessToken)
+	}
+}
@@ -0,0 +1,226 @@
+//go:build linux || netbsd || openbsd
+// +build linux netbsd openbsd
+
+package auth
+
+import (
+	"errors"
+	"path/filepath"
+	"sync"
+
+	"github.com/godbus/dbus/v5"
+)
+
+const (
+	secretServiceName       string          = "org.freedesktop.secrets"
+	secretServicePath       dbus.ObjectPath = "/org/freedesktop/secrets"
+	secretServiceCollection dbus.ObjectPath = "/org/freedesktop/secrets/aliases/default"
+	secretServiceNoPrompt   dbus.ObjectPath = "/"
+	secretServiceAttribute  string          = "topos"
+)
+
+var errSecretServiceLocked = errors.New("secret service collection is locked")
+
+type secretServiceSecret struct {
+	Session     dbus.ObjectPath
+	Parameters  []byte
+	Value       []byte
+	ContentType string
+}
+
+// SecretServiceTokenStore stores tokens in a freedesktop.org Secret Service
+// keyring such as GNOME Keyring or KWallet. Items are labelled by Service and
+// the token key.
+type SecretServiceTokenStore struct {
+	Conn    *dbus.Conn
+	Service string
+
+	// LockPath is the file locked while tokens are refreshed, so that other
+	// processes using the keyring wait for rotated refresh tokens. If empty,
+	// refreshes are only serialized within the process.
+	LockPath string
+
+	lock sync.Mutex
+}
+
+// NewSecretServiceTokenStore returns a store using the Secret Service
+// reachable over conn, which may be a private bus for tests.
+func NewSecretServiceTokenStore(conn *dbus.Conn, service string) *SecretServiceTokenStore {
+	return &SecretServiceTokenStore{
+		Conn:    conn,
+		Service: service,
+	}
+}
+
+// NewSystemSecretServiceTokenStore returns a store for the profile using the
+// Secret Service on the session bus.
+func NewSystemSecretServiceTokenStore(profile string) (*SecretServiceTokenStore, error) {
+	conn, err := dbus.SessionBus()
+	if err != nil {
+		return nil, err
+	}
+
+	profileDir, err := profileDirectory(profile)
+	if err != nil {
+		return nil, err
+	}
+
+	service := secretServiceAttribute
+	if profile != DefaultProfile {
+		service += "/" + profile
+	}
+
+	store := NewSecretServiceTokenStore(conn, service)
+	store.LockPath = filepath.Join(profileDir, ".lock")
+	return store, nil
+}
+
+func systemSecretServiceTokenStore(profile string) (TokenStore, error) {
+	return NewSystemSecretServiceTokenStore(profile)
+}
+
+// Lock takes an exclusive lock on LockPath, blocking until other processes
+// release it.
+func (s *SecretServiceTokenStore) Lock() (func(), error) {
+	if s.LockPath != "" {
+		return lockPath(s.LockPath)
+	}
+
+	s.lock.Lock()
+	return s.lock.Unlock, nil
+}
+
+func (s *SecretServiceTokenStore) service() dbus.BusObject {
+	return s.Conn.Object(secretServiceName, secretServicePath)
+}
+
+func (s *SecretServiceTokenStore) attributes(key string) map[string]string {
+	return map[string]string{
+		"service": s.Service,
+		"account": key,
+	}
+}
+
+func (s *SecretServiceTokenStore) openSession() (dbus.ObjectPath, error) {
+	var output dbus.Variant
+	var session dbus.ObjectPath
+	if err := s.service().Call("org.freedesktop.Secret.Service.OpenSession", 0, "plain", dbus.MakeVariant("")).Store(&output, &session); err != nil {
+		return "", err
+	}
+
+	return session, nil
+}
+
+func (s *SecretServiceTokenStore) closeSession(session dbus.ObjectPath) {
+	s.Conn.Object(secretServiceName, session).Call("org.freedesktop.Secret.Session.Close", 0)
+}
+
+func (s *SecretServiceTokenStore) unlock(objects []dbus.ObjectPath) error {
+	if len(objects) == 0 {
+		return nil
+	}
+
+	var unlocked []dbus.ObjectPath
+	var prompt dbus.ObjectPath
+	if err := s.service().Call("org.freedesktop.Secret.Service.Unlock", 0, objects).Store(&unlocked, &prompt); err != nil {
+		return err
+	}
+
+	if prompt != secretServiceNoPrompt || len(unlocked) != len(objects) {
+		return errSecretServiceLocked
+	}
+
+	return nil
+}
+
+func (s *SecretServiceTokenStore) searchItems(key string) ([]dbus.ObjectPath, error) {
+	var unlocked, locked []dbus.ObjectPath
+	if err := s.service().Call("org.freedesktop.Secret.Service.SearchItems", 0, s.attributes(key)).Store(&unlocked, &locked); err != nil {
+		return nil, err
+	}
+
+	if err := s.unlock(locked); err != nil {
+		return nil, err
+	}
+
+	return append(unlocked, locked...), nil
+}
+
+func (s *SecretServiceTokenStore) Token(key string) (string, error) {
+	items, err := s.searchItems(key)
+	if err != nil {
+		return "", err
+	}
+
+	if len(items) == 0 {
+		return "", ErrTokenNotFound
+	}
+
+	session, err := s.openSession()
+	if err != nil {
+		return "", err
+	}
+
+	defer s.closeSession(session)
+	secret := secretServiceSecret{}
+	if err := s.Conn.Object(secretServiceName, items[0]).Call("org.freedesktop.Secret.Item.GetSecret", 0, session).Store(&secret); err != nil {
+		return "", err
+	}
+
+	return string(secret.Value), nil
+}
+
+func (s *SecretServiceTokenStore) SetToken(key, token string) error {
+	if err := s.unlock([]dbus.ObjectPath{secretServiceCollection}); err != nil {
+		return err
+	}
+
+	session, err := s.openSession()
+	if err != nil {
+		return err
+	}
+
+	defer s.closeSession(session)
+	properties := map[string]dbus.Variant{
+		"org.freedesktop.Secret.Item.Label":      dbus.MakeVariant(s.Service + " " + key),
+		"org.freedesktop.Secret.Item.Attributes": dbus.MakeVariant(s.attributes(key)),
+	}
+
+	secret := secretServiceSecret{
+		Session:     session,
+		Parameters:  []byte{},
+		Value:       []byte(token),
+		ContentType: "text/plain",
+	}
+
+	var item, prompt dbus.ObjectPath
+	if err := s.Conn.Object(secretServiceName, secretServiceCollection).Call("org.freedesktop.Secret.Collection.CreateItem", 0, properties, secret, true).Store(&item, &prompt); err != nil {
+		return err
+	}
+
+	if prompt != secretServiceNoPrompt {
+		return errSecretServiceLocked
+	}
+
+	return nil
+}
+
+func (s *SecretServiceTokenStore) DeleteToken(key string) error {
+	items, err := s.searchItems(key)
+	if err != nil {
+		return err
+	}
+
+	for _, item := range items {
+		var prompt dbus.ObjectPath
+		if err := s.Conn.Object(secretServiceName, item).Call("org.freedesktop.Secret.Item.Delete", 0).Store(&prompt); err != nil {
+			return err
+		}
+
+		if prompt != secretServiceNoPrompt {
+			return errSecretServiceLocked
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,13 @@
+//go:build !linux && !netbsd && !openbsd
+// +build !linux,!netbsd,!openbsd
+
+package auth
+
+import (
+	"fmt"
+	"runtime"
+)
+
+func systemSecretServiceTokenStore(profile string) (TokenStore, error) {
+	return nil, fmt.Errorf("secret service token store is not supported on %s", runtime.GOOS)
+}
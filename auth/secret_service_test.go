@@ -0,0 +1,308 @@
+//go:build linux || netbsd || openbsd
+// +build linux netbsd openbsd
+
+package auth
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+)
+
+// privateBus starts a dbus-daemon in dir and returns its address and a
+// function stopping it.
+func privateBus(t *testing.T, dir string) (string, func()) {
+	t.Helper()
+
+	if _, err := exec.LookPath("dbus-daemon"); err != nil {
+		t.Skip("dbus-daemon not found")
+	}
+
+	cmd := exec.Command("dbus-daemon", "--session", "--nofork", "--print-address", "--address=unix:dir="+dir)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	stop := func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	}
+
+	address, err := bufio.NewReader(stdout).ReadString('\n')
+	if err != nil {
+		stop()
+		t.Fatal(err)
+	}
+
+	return strings.TrimSpace(address), stop
+}
+
+type fakeSecretItem struct {
+	service    *fakeSecretService
+	path       dbus.ObjectPath
+	attributes map[string]string
+	value      []byte
+}
+
+func (item *fakeSecretItem) GetSecret(session dbus.ObjectPath) (secretServiceSecret, *dbus.Error) {
+	item.service.lock.Lock()
+	defer item.service.lock.Unlock()
+
+	return secretServiceSecret{
+		Session:     session,
+		Parameters:  []byte{},
+		Value:       item.value,
+		ContentType: "text/plain",
+	}, nil
+}
+
+func (item *fakeSecretItem) Delete() (dbus.ObjectPath, *dbus.Error) {
+	item.service.lock.Lock()
+	defer item.service.lock.Unlock()
+
+	delete(item.service.items, item.path)
+	return secretServiceNoPrompt, nil
+}
+
+type fakeSecretSession struct{}
+
+func (fakeSecretSession) Close() *dbus.Error {
+	return nil
+}
+
+// fakeSecretService implements the parts of the Secret Service API used by
+// SecretServiceTokenStore, keeping items in memory. Its default collection is
+// always unlocked.
+type fakeSecretService struct {
+	conn *dbus.Conn
+
+	lock   sync.Mutex
+	items  map[dbus.ObjectPath]*fakeSecretItem
+	nextID int
+	opened int
+}
+
+func (s *fakeSecretService) OpenSession(algorithm string, input dbus.Variant) (dbus.Variant, dbus.ObjectPath, *dbus.Error) {
+	if algorithm != "plain" {
+		return dbus.Variant{}, "", dbus.MakeFailedError(fmt.Errorf("unsupported algorithm %q", algorithm))
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.opened++
+	session := dbus.ObjectPath(fmt.Sprintf("/org/freedesktop/secrets/session/%d", s.opened))
+	s.conn.Export(fakeSecretSession{}, session, "org.freedesktop.Secret.Session")
+	return dbus.MakeVariant(""), session, nil
+}
+
+func (s *fakeSecretService) SearchItems(attributes map[string]string) ([]dbus.ObjectPath, []dbus.ObjectPath, *dbus.Error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	unlocked := []dbus.ObjectPath{}
+	for path, item := range s.items {
+		if matchAttributes(item.attributes, attributes) {
+			unlocked = append(unlocked, path)
+		}
+	}
+
+	return unlocked, []dbus.ObjectPath{}, nil
+}
+
+func (s *fakeSecretService) Unlock(objects []dbus.ObjectPath) ([]dbus.ObjectPath, dbus.ObjectPath, *dbus.Error) {
+	return objects, secretServiceNoPrompt, nil
+}
+
+func (s *fakeSecretService) CreateItem(properties map[string]dbus.Variant, secret secretServiceSecret, replace bool) (dbus.ObjectPath, dbus.ObjectPath, *dbus.Error) {
+	attributes, ok := properties["org.freedesktop.Secret.Item.Attributes"].Value().(map[string]string)
+	if !ok {
+		return "", "", dbus.MakeFailedError(fmt.Errorf("missing attributes"))
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if replace {
+		for _, item := range s.items {
+			if matchAttributes(item.attributes, attributes) {
+				item.value = secret.Value
+				return item.path, secretServiceNoPrompt, nil
+			}
+		}
+	}
+
+	s.nextID++
+	item := &fakeSecretItem{
+		service:    s,
+		path:       dbus.ObjectPath(fmt.Sprintf("/org/freedesktop/secrets/collection/login/%d", s.nextID)),
+		attributes: attributes,
+		value:      secret.Value,
+	}
+
+	s.items[item.path] = item
+	s.conn.Export(item, item.path, "org.freedesktop.Secret.Item")
+	return item.path, secretServiceNoPrompt, nil
+}
+
+func matchAttributes(attributes, query map[string]string) bool {
+	for name, value := range query {
+		if attributes[name] != value {
+			return false
+		}
+	}
+
+	return true
+}
+
+// newFakeSecretService serves a fakeSecretService on the bus at address
+// over the returned connection.
+func newFakeSecretService(t *testing.T, address string) *dbus.Conn {
+	t.Helper()
+
+	conn, err := dbus.Connect(address)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	service := &fakeSecretService{
+		conn:  conn,
+		items: map[dbus.ObjectPath]*fakeSecretItem{},
+	}
+
+	if err := conn.Export(service, secretServicePath, "org.freedesktop.Secret.Service"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := conn.Export(service, secretServiceCollection, "org.freedesktop.Secret.Collection"); err != nil {
+		t.Fatal(err)
+	}
+
+	reply, err := conn.RequestName(secretServiceName, dbus.NameFlagDoNotQueue)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if reply != dbus.RequestNameReplyPrimaryOwner {
+		t.Fatalf("failed to own %s", secretServiceName)
+	}
+
+	return conn
+}
+
+func tempDir(t *testing.T) (string, func()) {
+	t.Helper()
+
+	dir, err := ioutil.TempDir("", "topos-auth")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return dir, func() {
+		os.RemoveAll(dir)
+	}
+}
+
+func TestSecretServiceTokenStore(t *testing.T) {
+	dir, removeDir := tempDir(t)
+	defer removeDir()
+
+	address, stopBus := privateBus(t, dir)
+	defer stopBus()
+
+	serviceConn := newFakeSecretService(t, address)
+	defer serviceConn.Close()
+
+	conn, err := dbus.Connect(address)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer conn.Close()
+
+	store := NewSecretServiceTokenStore(conn, "topos-test")
+	other := NewSecretServiceTokenStore(conn, "topos-test/other")
+	if _, err := store.Token(refreshTokenKey); err != ErrTokenNotFound {
+		t.Fatalf("Token() error = %v, want %v", err, ErrTokenNotFound)
+	}
+
+	for _, token := range []string{"first", "rotated"} {
+		if err := store.SetToken(refreshTokenKey, token); err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := store.Token(refreshTokenKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got != token {
+			t.Errorf("Token() = %q, want %q", got, token)
+		}
+	}
+
+	if _, err := other.Token(refreshTokenKey); err != ErrTokenNotFound {
+		t.Errorf("other service Token() error = %v, want %v", err, ErrTokenNotFound)
+	}
+
+	if err := store.DeleteToken(refreshTokenKey); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := store.Token(refreshTokenKey); err != ErrTokenNotFound {
+		t.Errorf("Token() after DeleteToken error = %v, want %v", err, ErrTokenNotFound)
+	}
+}
+
+func TestSecretServiceTokenStoreLock(t *testing.T) {
+	dir, removeDir := tempDir(t)
+	defer removeDir()
+
+	var store TokenStore = &SecretServiceTokenStore{
+		LockPath: filepath.Join(dir, ".lock"),
+	}
+
+	if _, ok := store.(LockingTokenStore); !ok {
+		t.Fatal("SecretServiceTokenStore is not a LockingTokenStore")
+	}
+
+	unlock, err := lockTokenStore(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	locked := make(chan struct{})
+	go func() {
+		unlock, err := lockTokenStore(store)
+		if err != nil {
+			t.Error(err)
+		} else {
+			unlock()
+		}
+
+		close(locked)
+	}()
+
+	select {
+	case <-locked:
+		t.Fatal("lock acquired twice")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	unlock()
+	<-locked
+}
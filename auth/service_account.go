@@ -0,0 +1,138 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"time"
+
+	"google.golang.org/grpc/credentials"
+)
+
+// ErrNoServiceAccount is returned when no service account is configured.
+var ErrNoServiceAccount = errors.New("no service account configured")
+
+// ServiceAccount holds the client ID and secret of a machine-to-machine
+// application.
+type ServiceAccount struct {
+	ClientID     string `json:"client_id"`
+	ClientSecret string `json:"client_secret"`
+}
+
+// LoadServiceAccountFile reads a JSON encoded ServiceAccount from path.
+func LoadServiceAccountFile(path string) (*ServiceAccount, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	defer file.Close()
+	account := &ServiceAccount{}
+	if err := json.NewDecoder(file).Decode(account); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	if account.ClientID == "" || account.ClientSecret == "" {
+		return nil, fmt.Errorf("%s: missing client_id or client_secret", path)
+	}
+
+	return account, nil
+}
+
+// LoadServiceAccount returns the service account from TOPOS_CLIENT_ID and
+// TOPOS_CLIENT_SECRET, or else from the file named by
+// TOPOS_SERVICE_ACCOUNT_FILE or the config's ServiceAccountFile.
+func LoadServiceAccount(config *Config) (*ServiceAccount, error) {
+	clientID, clientSecret := os.Getenv("TOPOS_CLIENT_ID"), os.Getenv("TOPOS_CLIENT_SECRET")
+	if clientID != "" && clientSecret != "" {
+		return &ServiceAccount{
+			ClientID:     clientID,
+			ClientSecret: clientSecret,
+		}, nil
+	}
+
+	if path := os.Getenv("TOPOS_SERVICE_ACCOUNT_FILE"); path != "" {
+		return LoadServiceAccountFile(path)
+	}
+
+	if config != nil && config.ServiceAccountFile != "" {
+		return LoadServiceAccountFile(config.ServiceAccountFile)
+	}
+
+	return nil, ErrNoServiceAccount
+}
+
+type serviceAccountCredentials struct {
+	config  *Config
+	account *ServiceAccount
+	cache   *tokenCache
+	scoped  *scopedTokenCache
+}
+
+// NewServiceAccountCredentials returns per-RPC credentials that obtain access
+// tokens with the client credentials grant, caching and refreshing them
+// according to DefaultRefreshPolicy.
+func NewServiceAccountCredentials(config *Config, account *ServiceAccount) credentials.PerRPCCredentials {
+	return newServiceAccountCredentials(config, account, DefaultRefreshPolicy, nil)
+}
+
+func newServiceAccountCredentials(config *Config, account *ServiceAccount, policy RefreshPolicy, observe RefreshObserver) *serviceAccountCredentials {
+	c := &serviceAccountCredentials{
+		config:  config,
+		account: account,
+	}
+
+	c.cache = newTokenCache(policy, observe, c.refresh)
+	return c
+}
+
+func (c *Config) getTokenClientCredentials(ctx context.Context, account *ServiceAccount, scope TokenScope) (*getTokenResponse, error) {
+	payload := url.Values{}
+	payload.Set("grant_type", "client_credentials")
+	payload.Set("client_id", account.ClientID)
+	payload.Set("client_secret", account.ClientSecret)
+	payload.Set("audience", c.Audience)
+	scope.setPayload(payload)
+
+	return c.getToken(ctx, payload)
+}
+
+func (c *serviceAccountCredentials) refresh(ctx context.Context, validUntil int64) (string, int64, error) {
+	return c.refreshScoped(ctx, TokenScope{})
+}
+
+func (c *serviceAccountCredentials) refreshScoped(ctx context.Context, scope TokenScope) (string, int64, error) {
+	now := time.Now().Unix()
+	response, err := c.config.getTokenClientCredentials(ctx, c.account, scope)
+	if err != nil {
+		return "", 0, err
+	}
+
+	return response.AccessToken, now + response.ExpiresIn, nil
+}
+
+func (c *serviceAccountCredentials) setScopeResolver(resolve ScopeResolver) {
+	c.scoped = newScopedTokenCache(c.cache.policy, c.cache.observe, resolve, c.refreshScoped)
+}
+
+func (c *serviceAccountCredentials) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	accessToken, err := c.scoped.token(ctx, c.cache, uri)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]string{
+		"authorization": accessToken,
+	}, nil
+}
+
+func (c *serviceAccountCredentials) invalidate(accessToken string) {
+	c.scoped.invalidate(c.cache, accessToken)
+}
+
+func (*serviceAccountCredentials) RequireTransportSecurity() bool {
+	return true
+}
@@ -0,0 +1,225 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const (
+	refreshTokenKey string = "refresh_token"
+	accessTokenKey  string = "access_token"
+)
+
+// ErrTokenNotFound is returned by a TokenStore when no token is stored under
+// the requested key.
+var ErrTokenNotFound = errors.New("token not found")
+
+// A TokenStore persists the tokens obtained by Login.
+type TokenStore interface {
+	Token(key string) (string, error)
+	SetToken(key, token string) error
+	DeleteToken(key string) error
+}
+
+// A LockingTokenStore can be locked while its tokens are refreshed, so that
+// concurrent users of the store, possibly in other processes, do not refresh
+// the same tokens at the same time.
+type LockingTokenStore interface {
+	TokenStore
+	Lock() (unlock func(), err error)
+}
+
+func lockTokenStore(store TokenStore) (func(), error) {
+	if store, ok := store.(LockingTokenStore); ok {
+		return store.Lock()
+	}
+
+	return func() {}, nil
+}
+
+// writeString atomically replaces the contents of path with s.
+func writeString(path, s string) error {
+	file, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".")
+	if err != nil {
+		return err
+	}
+
+	if err := writeFile(file, s); err != nil {
+		os.Remove(file.Name())
+		return err
+	}
+
+	if err := os.Rename(file.Name(), path); err != nil {
+		os.Remove(file.Name())
+		return err
+	}
+
+	return nil
+}
+
+func writeFile(file *os.File, s string) error {
+	if err := file.Chmod(0600); err != nil {
+		file.Close()
+		return err
+	}
+
+	if _, err := io.WriteString(file, s); err != nil {
+		file.Close()
+		return err
+	}
+
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+
+	return file.Close()
+}
+
+func readString(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+
+	s, err := ioutil.ReadAll(file)
+	if err := file.Close(); err != nil {
+		return "", err
+	}
+
+	return string(s), err
+}
+
+// FileTokenStore stores each token in a file named after its key in Dir.
+type FileTokenStore struct {
+	Dir string
+}
+
+func (s *FileTokenStore) Token(key string) (string, error) {
+	token, err := readString(filepath.Join(s.Dir, key))
+	if os.IsNotExist(err) {
+		return "", ErrTokenNotFound
+	}
+
+	return token, err
+}
+
+func (s *FileTokenStore) SetToken(key, token string) error {
+	if err := os.MkdirAll(s.Dir, 0700); err != nil {
+		return err
+	}
+
+	return writeString(filepath.Join(s.Dir, key), token)
+}
+
+func (s *FileTokenStore) DeleteToken(key string) error {
+	if err := os.Remove(filepath.Join(s.Dir, key)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
+
+// Lock takes an exclusive lock on the .lock file in Dir, blocking until other
+// processes release it.
+func (s *FileTokenStore) Lock() (func(), error) {
+	return lockPath(filepath.Join(s.Dir, ".lock"))
+}
+
+// lockPath takes an exclusive lock on the file at path, creating it and its
+// directory if needed.
+func lockPath(path string) (func(), error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := lockFile(file); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return func() {
+		unlockFile(file)
+		file.Close()
+	}, nil
+}
+
+// MemoryTokenStore keeps tokens in memory. It is safe for concurrent use.
+type MemoryTokenStore struct {
+	lock        sync.RWMutex
+	refreshLock sync.Mutex
+	tokens      map[string]string
+}
+
+func NewMemoryTokenStore() *MemoryTokenStore {
+	return &MemoryTokenStore{
+		tokens: map[string]string{},
+	}
+}
+
+func (s *MemoryTokenStore) Token(key string) (string, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	token, ok := s.tokens[key]
+	if !ok {
+		return "", ErrTokenNotFound
+	}
+
+	return token, nil
+}
+
+func (s *MemoryTokenStore) SetToken(key, token string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.tokens[key] = token
+	return nil
+}
+
+func (s *MemoryTokenStore) DeleteToken(key string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	delete(s.tokens, key)
+	return nil
+}
+
+func (s *MemoryTokenStore) Lock() (func(), error) {
+	s.refreshLock.Lock()
+	return s.refreshLock.Unlock, nil
+}
+
+func profileFileTokenStore(profile string) (*FileTokenStore, error) {
+	profileDir, err := profileDirectory(profile)
+	if err != nil {
+		return nil, err
+	}
+
+	return &FileTokenStore{Dir: profileDir}, nil
+}
+
+func (c *Config) tokenStore(profile string) (TokenStore, error) {
+	switch c.TokenStore {
+	case "", "file":
+		return profileFileTokenStore(profile)
+	case "secret-service":
+		if err := validateProfile(profile); err != nil {
+			return nil, err
+		}
+
+		return systemSecretServiceTokenStore(profile)
+	default:
+		return nil, fmt.Errorf("unknown token store %q", c.TokenStore)
+	}
+}
@@ -0,0 +1,157 @@
+package auth
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+// TransportSecurity selects how connections made by Dial are secured.
+type TransportSecurity int
+
+const (
+	// TransportSecurityAuto uses plaintext for loopback addresses and unix
+	// domain sockets, and verified TLS for everything else. Loopback
+	// connections use TLS too when root CAs or client certificates are set.
+	TransportSecurityAuto TransportSecurity = iota
+
+	// TransportSecuritySecure always uses verified TLS.
+	TransportSecuritySecure
+
+	// TransportSecurityInsecure always uses plaintext. Dial refuses it for
+	// non-loopback addresses, since access tokens would be sent in the clear.
+	TransportSecurityInsecure
+
+	// TransportSecuritySkipVerify uses TLS without verifying the server's
+	// certificate. It is meant for development servers only.
+	TransportSecuritySkipVerify
+)
+
+// ErrPlaintextCredentials is returned by Dial when plaintext is requested for
+// a non-loopback address.
+var ErrPlaintextCredentials = errors.New("refusing to send credentials over plaintext to a non-loopback address")
+
+func (s TransportSecurity) String() string {
+	switch s {
+	case TransportSecurityAuto:
+		return "auto"
+	case TransportSecuritySecure:
+		return "secure"
+	case TransportSecurityInsecure:
+		return "insecure"
+	case TransportSecuritySkipVerify:
+		return "skip-verify"
+	default:
+		return fmt.Sprintf("TransportSecurity(%d)", int(s))
+	}
+}
+
+func unixSocketPath(addr string) (string, bool) {
+	for _, prefix := range []string{"unix://", "unix:"} {
+		if strings.HasPrefix(addr, prefix) {
+			return strings.TrimPrefix(addr, prefix), true
+		}
+	}
+
+	return "", false
+}
+
+func targetHost(addr string) string {
+	if i := strings.Index(addr, ":///"); i >= 0 {
+		addr = addr[i+len(":///"):]
+	}
+
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return strings.Trim(addr, "[]")
+	}
+
+	return host
+}
+
+// isLoopback reports whether addr is a unix domain socket or names a loopback
+// host, such as localhost, 127.0.0.1 or [::1].
+func isLoopback(addr string) bool {
+	if _, ok := unixSocketPath(addr); ok {
+		return true
+	}
+
+	host := targetHost(addr)
+	if host == "localhost" {
+		return true
+	}
+
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// loopbackCredentials allows per-RPC credentials over plaintext connections,
+// which Dial only permits for loopback addresses.
+type loopbackCredentials struct {
+	credentials.PerRPCCredentials
+}
+
+func (loopbackCredentials) RequireTransportSecurity() bool {
+	return false
+}
+
+func dialUnix(ctx context.Context, addr string) (net.Conn, error) {
+	path, _ := unixSocketPath(addr)
+	return (&net.Dialer{}).DialContext(ctx, "unix", path)
+}
+
+func (options *dialOptions) tlsConfig(addr string) *tls.Config {
+	tlsConfig := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		RootCAs:      options.rootCAs,
+		Certificates: options.certificates,
+	}
+
+	if options.transportSecurity == TransportSecuritySkipVerify {
+		log.Printf("topos: WARNING: TLS certificate verification is disabled for %s; do not use this outside development", addr)
+		tlsConfig.InsecureSkipVerify = true
+	}
+
+	return tlsConfig
+}
+
+// transportOptions returns the grpc.DialOptions securing the connection to
+// addr according to the policy, wrapping creds as needed.
+func (options *dialOptions) transportOptions(addr string, creds credentials.PerRPCCredentials) ([]grpc.DialOption, error) {
+	var grpcOptions []grpc.DialOption
+	if _, ok := unixSocketPath(addr); ok {
+		grpcOptions = append(grpcOptions, grpc.WithContextDialer(dialUnix))
+	}
+
+	loopback := isLoopback(addr)
+	plaintext := false
+	switch options.transportSecurity {
+	case TransportSecurityAuto:
+		plaintext = loopback && options.rootCAs == nil && len(options.certificates) == 0
+	case TransportSecurityInsecure:
+		if !loopback {
+			return nil, ErrPlaintextCredentials
+		}
+
+		plaintext = true
+	}
+
+	if plaintext {
+		return append(grpcOptions,
+			grpc.WithInsecure(),
+			grpc.WithPerRPCCredentials(loopbackCredentials{creds}),
+		), nil
+	}
+
+	return append(grpcOptions,
+		grpc.WithTransportCredentials(credentials.NewTLS(options.tlsConfig(addr))),
+		grpc.WithPerRPCCredentials(creds),
+	), nil
+}
@@ -0,0 +1,246 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	errMissingToken      = status.Error(codes.Unauthenticated, "missing authorization metadata")
+	errMalformedToken    = status.Error(codes.Unauthenticated, "malformed access token")
+	errInvalidSignature  = status.Error(codes.Unauthenticated, "invalid access token signature")
+	errInvalidIssuer     = status.Error(codes.Unauthenticated, "invalid access token issuer")
+	errInvalidAudience   = status.Error(codes.Unauthenticated, "invalid access token audience")
+	errExpiredToken      = status.Error(codes.Unauthenticated, "access token expired")
+	errTokenNotYetValid  = status.Error(codes.Unauthenticated, "access token not yet valid")
+	errUnsupportedSigAlg = status.Error(codes.Unauthenticated, "unsupported access token signing algorithm")
+)
+
+// A Verifier verifies Topos JWT access tokens received by gRPC servers.
+type Verifier struct {
+	Keys           KeySet
+	Issuer         string
+	Audience       string
+	RequiredScopes []string
+
+	// Leeway is the tolerated clock skew when checking exp and nbf.
+	Leeway time.Duration
+}
+
+// NewVerifier returns a Verifier for tokens issued by the config's issuer to
+// its audience, fetching signing keys from the issuer's JWKS endpoint.
+func NewVerifier(config *Config, requiredScopes ...string) *Verifier {
+	return &Verifier{
+		Keys:           NewRemoteKeySet(config.jwksURL()),
+		Issuer:         config.Issuer,
+		Audience:       config.Audience,
+		RequiredScopes: requiredScopes,
+		Leeway:         DefaultRefreshPolicy.ClockSkew,
+	}
+}
+
+type jwtHeader struct {
+	Algorithm string `json:"alg"`
+	KeyID     string `json:"kid"`
+}
+
+func hashFunc(algorithm string) (crypto.Hash, bool) {
+	switch algorithm[2:] {
+	case "256":
+		return crypto.SHA256, true
+	case "384":
+		return crypto.SHA384, true
+	case "512":
+		return crypto.SHA512, true
+	default:
+		return 0, false
+	}
+}
+
+func verifySignature(algorithm string, key crypto.PublicKey, signed string, signature []byte) error {
+	if len(algorithm) != 5 {
+		return errUnsupportedSigAlg
+	}
+
+	hash, ok := hashFunc(algorithm)
+	if !ok {
+		return errUnsupportedSigAlg
+	}
+
+	h := hash.New()
+	h.Write([]byte(signed))
+	digest := h.Sum(nil)
+
+	switch algorithm[:2] {
+	case "RS":
+		key, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return errInvalidSignature
+		}
+
+		if err := rsa.VerifyPKCS1v15(key, hash, digest, signature); err != nil {
+			return errInvalidSignature
+		}
+
+		return nil
+	case "ES":
+		key, ok := key.(*ecdsa.PublicKey)
+		if !ok || len(signature)%2 != 0 {
+			return errInvalidSignature
+		}
+
+		r := new(big.Int).SetBytes(signature[:len(signature)/2])
+		s := new(big.Int).SetBytes(signature[len(signature)/2:])
+		if !ecdsa.Verify(key, digest, r, s) {
+			return errInvalidSignature
+		}
+
+		return nil
+	default:
+		return errUnsupportedSigAlg
+	}
+}
+
+func normalizeIssuer(issuer string) string {
+	return strings.TrimSuffix(issuer, "/")
+}
+
+// Verify checks the signature, issuer, audience, validity period and scopes
+// of token and returns its claims. Errors are gRPC status errors with code
+// Unauthenticated, or PermissionDenied for missing scopes.
+func (v *Verifier) Verify(ctx context.Context, token string) (*Claims, error) {
+	components := strings.Split(token, ".")
+	if len(components) != 3 {
+		return nil, errMalformedToken
+	}
+
+	headerJSON, err := base64.RawURLEncoding.DecodeString(components[0])
+	if err != nil {
+		return nil, errMalformedToken
+	}
+
+	header := &jwtHeader{}
+	if err := json.Unmarshal(headerJSON, header); err != nil {
+		return nil, errMalformedToken
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(components[2])
+	if err != nil {
+		return nil, errMalformedToken
+	}
+
+	key, err := v.Keys.Key(ctx, header.KeyID)
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+
+	if err := verifySignature(header.Algorithm, key, components[0]+"."+components[1], signature); err != nil {
+		return nil, err
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(components[1])
+	if err != nil {
+		return nil, errMalformedToken
+	}
+
+	claims, err := parseClaims(payload)
+	if err != nil {
+		return nil, errMalformedToken
+	}
+
+	if v.Issuer != "" && normalizeIssuer(claims.Issuer) != normalizeIssuer(v.Issuer) {
+		return nil, errInvalidIssuer
+	}
+
+	if v.Audience != "" && !claims.Audience.Contains(v.Audience) {
+		return nil, errInvalidAudience
+	}
+
+	now := time.Now()
+	if claims.ExpiresAt == 0 || !now.Before(time.Unix(claims.ExpiresAt, 0).Add(v.Leeway)) {
+		return nil, errExpiredToken
+	}
+
+	if claims.NotBefore != 0 && now.Add(v.Leeway).Before(time.Unix(claims.NotBefore, 0)) {
+		return nil, errTokenNotYetValid
+	}
+
+	for _, scope := range v.RequiredScopes {
+		if !claims.HasScope(scope) {
+			return nil, status.Errorf(codes.PermissionDenied, "missing scope %q", scope)
+		}
+	}
+
+	return claims, nil
+}
+
+func bearerToken(authorization string) string {
+	if len(authorization) > 7 && strings.EqualFold(authorization[:7], "bearer ") {
+		return authorization[7:]
+	}
+
+	return authorization
+}
+
+func (v *Verifier) verifyIncomingContext(ctx context.Context) (context.Context, error) {
+	authorizationMetadata := authorizationMetadataFromIncomingContext(ctx)
+	if len(authorizationMetadata) == 0 || authorizationMetadata[0] == "" {
+		return nil, errMissingToken
+	}
+
+	claims, err := v.Verify(ctx, bearerToken(authorizationMetadata[0]))
+	if err != nil {
+		return nil, err
+	}
+
+	return NewContextWithClaims(ctx, claims), nil
+}
+
+// UnaryServerInterceptor rejects calls without a valid access token and
+// makes the token's claims available through ClaimsFromContext.
+func (v *Verifier) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, err := v.verifyIncomingContext(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		return handler(ctx, req)
+	}
+}
+
+type verifiedServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *verifiedServerStream) Context() context.Context {
+	return s.ctx
+}
+
+// StreamServerInterceptor is the streaming counterpart of
+// UnaryServerInterceptor.
+func (v *Verifier) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := v.verifyIncomingContext(stream.Context())
+		if err != nil {
+			return err
+		}
+
+		return handler(srv, &verifiedServerStream{
+			ServerStream: stream,
+			ctx:          ctx,
+		})
+	}
+}
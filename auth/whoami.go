@@ -0,0 +1,30 @@
+package auth
+
+import (
+	"context"
+	"errors"
+
+	"google.golang.org/grpc/credentials"
+)
+
+// ErrNoAccessToken is returned by WhoAmI when the credentials do not attach
+// an access token, for example forwarding credentials outside of a request,
+// and by token exchange credentials when there is no subject token.
+var ErrNoAccessToken = errors.New("credentials provide no access token")
+
+// WhoAmI returns the claims of the access token creds attach to RPCs made with
+// ctx, refreshing it if needed. For forwarding credentials ctx must be the
+// incoming request's context. The claims are decoded but not verified.
+func WhoAmI(ctx context.Context, creds credentials.PerRPCCredentials) (*Claims, error) {
+	requestMetadata, err := creds.GetRequestMetadata(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	authorization := requestMetadata["authorization"]
+	if authorization == "" {
+		return nil, ErrNoAccessToken
+	}
+
+	return ParseUnverifiedClaims(bearerToken(authorization))
+}
@@ -1,13 +1,16 @@
 module github.com/topos-ai/topos-apis-go
 
-go 1.12
+go 1.13
 
 require (
+	github.com/godbus/dbus/v5 v5.1.0
 	github.com/golang/geo v0.0.0-20181008215305-476085157cff
+	github.com/golang/protobuf v1.3.2
 	github.com/topos-ai/topos-apis/genproto/go v0.0.0-20191205182609-96a7f60ff0b3
 	github.com/twpayne/go-geom v1.0.5
 	golang.org/x/net v0.0.0-20191209160850-c0dbc17a3553 // indirect
-	golang.org/x/sys v0.0.0-20191110163157-d32e6e3b99c4 // indirect
+	golang.org/x/oauth2 v0.0.0-20190604053449-0f29369cfe45
+	golang.org/x/sys v0.0.0-20191110163157-d32e6e3b99c4
 	google.golang.org/api v0.9.0
 	google.golang.org/grpc v1.25.1
 )
@@ -0,0 +1,160 @@
+package clientconn
+
+import (
+	"context"
+	"sync"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/status"
+)
+
+// ErrClosed is returned by calls made after Close or Shutdown.
+var ErrClosed = status.Error(codes.Canceled, "client is closed")
+
+// Conn tracks the calls service clients make on a connection, so that they
+// can be drained before the connection is closed.
+type Conn struct {
+	conn  *grpc.ClientConn
+	owned bool
+
+	lock     sync.Mutex
+	closing  bool
+	closed   bool
+	inFlight int
+	drained  chan struct{}
+	done     chan struct{}
+}
+
+// New wraps conn. Close closes conn only if the client owns it, that is if
+// it dialed conn itself.
+func New(conn *grpc.ClientConn, owned bool) *Conn {
+	return &Conn{
+		conn:  conn,
+		owned: owned,
+		done:  make(chan struct{}),
+	}
+}
+
+// ClientConn returns the underlying connection.
+func (c *Conn) ClientConn() *grpc.ClientConn {
+	return c.conn
+}
+
+func (c *Conn) begin(ctx context.Context) (context.Context, func(), error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.closing {
+		return nil, nil, ErrClosed
+	}
+
+	c.inFlight++
+	ctx, cancel := context.WithCancel(ctx)
+	go func() {
+		select {
+		case <-c.done:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	return ctx, func() {
+		cancel()
+
+		c.lock.Lock()
+		defer c.lock.Unlock()
+
+		c.inFlight--
+		if c.inFlight == 0 && c.drained != nil {
+			close(c.drained)
+			c.drained = nil
+		}
+	}, nil
+}
+
+// Do runs call, including any stream it opens and consumes, as one in-flight
+// call. The context passed to call is canceled if the client is closed.
+func (c *Conn) Do(ctx context.Context, call func(ctx context.Context) error) error {
+	ctx, end, err := c.begin(ctx)
+	if err != nil {
+		return err
+	}
+
+	defer end()
+	return call(ctx)
+}
+
+// State returns the connectivity state of the connection.
+func (c *Conn) State() connectivity.State {
+	return c.conn.GetState()
+}
+
+// WaitForReady blocks until the connection is ready or ctx is done.
+func (c *Conn) WaitForReady(ctx context.Context) error {
+	return WaitForReady(ctx, c.conn)
+}
+
+// WaitForReady blocks until conn is ready or ctx is done.
+func WaitForReady(ctx context.Context, conn *grpc.ClientConn) error {
+	for {
+		state := conn.GetState()
+		switch state {
+		case connectivity.Ready:
+			return nil
+		case connectivity.Shutdown:
+			return ErrClosed
+		}
+
+		if !conn.WaitForStateChange(ctx, state) {
+			return status.Errorf(codes.Unavailable, "connection is %s: %v", state, ctx.Err())
+		}
+	}
+}
+
+// Shutdown rejects new calls and waits for in-flight calls to complete before
+// closing. If ctx is done first, the remaining calls are canceled and the
+// context's error is returned.
+func (c *Conn) Shutdown(ctx context.Context) error {
+	c.lock.Lock()
+	c.closing = true
+	if c.inFlight > 0 && c.drained == nil {
+		c.drained = make(chan struct{})
+	}
+
+	drained := c.drained
+	c.lock.Unlock()
+
+	if drained != nil {
+		select {
+		case <-drained:
+		case <-ctx.Done():
+			c.Close()
+			return ctx.Err()
+		}
+	}
+
+	return c.Close()
+}
+
+// Close cancels in-flight calls and closes the connection if the client owns
+// it.
+func (c *Conn) Close() error {
+	c.lock.Lock()
+	c.closing = true
+	if c.closed {
+		c.lock.Unlock()
+		return nil
+	}
+
+	c.closed = true
+	close(c.done)
+	c.lock.Unlock()
+
+	if !c.owned {
+		return nil
+	}
+
+	return c.conn.Close()
+}
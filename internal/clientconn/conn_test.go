@@ -0,0 +1,87 @@
+package clientconn
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// startCall starts a call on c that returns once release is closed, or with
+// the call context's error when it is done. It returns the call's result.
+func startCall(t *testing.T, c *Conn, release <-chan struct{}) <-chan error {
+	t.Helper()
+
+	started := make(chan struct{})
+	result := make(chan error, 1)
+	go func() {
+		result <- c.Do(context.Background(), func(ctx context.Context) error {
+			close(started)
+			select {
+			case <-release:
+				return ctx.Err()
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		})
+	}()
+
+	<-started
+	return result
+}
+
+func TestShutdownDrains(t *testing.T) {
+	c := New(nil, false)
+	release := make(chan struct{})
+	result := startCall(t, c, release)
+
+	shutdown := make(chan error, 1)
+	go func() {
+		shutdown <- c.Shutdown(context.Background())
+	}()
+
+	select {
+	case err := <-shutdown:
+		t.Fatalf("Shutdown returned %v before the call completed", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	if err := c.Do(context.Background(), func(ctx context.Context) error { return nil }); err != ErrClosed {
+		t.Errorf("Do after Shutdown err = %v, want %v", err, ErrClosed)
+	}
+
+	close(release)
+	if err := <-result; err != nil {
+		t.Errorf("call err = %v, want nil", err)
+	}
+
+	if err := <-shutdown; err != nil {
+		t.Errorf("Shutdown err = %v, want nil", err)
+	}
+}
+
+func TestShutdownCancel(t *testing.T) {
+	c := New(nil, false)
+	result := startCall(t, c, nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	if err := c.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Shutdown err = %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	if err := <-result; err != context.Canceled {
+		t.Errorf("call err = %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestShutdownIdle(t *testing.T) {
+	c := New(nil, false)
+	if err := c.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.Close(); err != nil {
+		t.Errorf("Close after Shutdown err = %v, want nil", err)
+	}
+}
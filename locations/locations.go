@@ -11,29 +11,62 @@ import (
 	"github.com/twpayne/go-geom"
 	"google.golang.org/api/iterator"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
 
 	"github.com/topos-ai/topos-apis-go/auth"
 	"github.com/topos-ai/topos-apis-go/geometry"
+	"github.com/topos-ai/topos-apis-go/internal/clientconn"
 )
 
 type Client struct {
 	locationsClient locations.LocationsClient
-	conn            *grpc.ClientConn
+	conn            *clientconn.Conn
 }
 
-func NewClient(addr string, useLocalCredentials bool) (*Client, error) {
-	conn, err := auth.Dial(addr, useLocalCredentials)
+func NewClient(addr string, useLocalCredentials bool, opts ...auth.DialOption) (*Client, error) {
+	conn, err := auth.Dial(addr, useLocalCredentials, opts...)
 	if err != nil {
 		return nil, err
 	}
 
-	locationsClient := locations.NewLocationsClient(conn)
-	c := &Client{
-		conn:            conn,
-		locationsClient: locationsClient,
+	return newClient(conn, true), nil
+}
+
+// NewClientFromConn returns a Client using an existing connection, for
+// example one shared with other service clients. Closing the Client does not
+// close conn.
+func NewClientFromConn(conn *grpc.ClientConn) *Client {
+	return newClient(conn, false)
+}
+
+func newClient(conn *grpc.ClientConn, owned bool) *Client {
+	return &Client{
+		conn:            clientconn.New(conn, owned),
+		locationsClient: locations.NewLocationsClient(conn),
 	}
+}
+
+// State returns the connectivity state of the client's connection.
+func (c *Client) State() connectivity.State {
+	return c.conn.State()
+}
 
-	return c, nil
+// WaitForReady blocks until the client's connection is ready or ctx is done.
+func (c *Client) WaitForReady(ctx context.Context) error {
+	return c.conn.WaitForReady(ctx)
+}
+
+// Shutdown rejects new calls and waits for in-flight calls, including
+// streams such as RegionGeometry, to complete before closing the client. If
+// ctx is done first the remaining calls are canceled.
+func (c *Client) Shutdown(ctx context.Context) error {
+	return c.conn.Shutdown(ctx)
+}
+
+// Close cancels in-flight calls and closes the client's connection, unless
+// the client was created with NewClientFromConn.
+func (c *Client) Close() error {
+	return c.conn.Close()
 }
 
 func (c *Client) Region(ctx context.Context, region string) (*locations.Region, error) {
@@ -41,10 +74,23 @@ func (c *Client) Region(ctx context.Context, region string) (*locations.Region,
 		Name: region,
 	}
 
-	return c.locationsClient.GetRegion(ctx, req)
+	var response *locations.Region
+	err := c.conn.Do(ctx, func(ctx context.Context) error {
+		var err error
+		response, err = c.locationsClient.GetRegion(ctx, req)
+		return err
+	})
+
+	return response, err
 }
 
 func (c *Client) RegionGeometry(ctx context.Context, w io.Writer, region string, encoding geometryproto.Encoding) error {
+	return c.conn.Do(ctx, func(ctx context.Context) error {
+		return c.regionGeometry(ctx, w, region, encoding)
+	})
+}
+
+func (c *Client) regionGeometry(ctx context.Context, w io.Writer, region string, encoding geometryproto.Encoding) error {
 	req := &locations.GetRegionGeometryRequest{
 		Name:             region,
 		GeometryEncoding: encoding,
@@ -85,11 +131,19 @@ func (c *Client) SetRegion(ctx context.Context, region *locations.Region) error
 		Region: region,
 	}
 
-	_, err := c.locationsClient.SetRegion(ctx, req)
-	return err
+	return c.conn.Do(ctx, func(ctx context.Context) error {
+		_, err := c.locationsClient.SetRegion(ctx, req)
+		return err
+	})
 }
 
 func (c *Client) SetRegionGeometry(ctx context.Context, r io.Reader, name string, encoding geometryproto.Encoding) error {
+	return c.conn.Do(ctx, func(ctx context.Context) error {
+		return c.setRegionGeometry(ctx, r, name, encoding)
+	})
+}
+
+func (c *Client) setRegionGeometry(ctx context.Context, r io.Reader, name string, encoding geometryproto.Encoding) error {
 	client, err := c.locationsClient.SetRegionGeometry(ctx)
 	if err != nil {
 		return err
@@ -134,7 +188,13 @@ func (c *Client) LocateRegions(ctx context.Context, regionType string, latitude,
 		},
 	}
 
-	response, err := c.locationsClient.LocateRegions(ctx, req)
+	var response *locations.LocateRegionsResponse
+	err := c.conn.Do(ctx, func(ctx context.Context) error {
+		var err error
+		response, err = c.locationsClient.LocateRegions(ctx, req)
+		return err
+	})
+
 	if err != nil {
 		return nil, err
 	}
@@ -174,7 +234,13 @@ func (c *Client) SearchRegions(ctx context.Context, options ...SearchRegionOptio
 			req.PageSize = int32(pageSize)
 		}
 
-		response, err := c.locationsClient.SearchRegions(ctx, req)
+		var response *locations.SearchRegionsResponse
+		err := c.conn.Do(ctx, func(ctx context.Context) error {
+			var err error
+			response, err = c.locationsClient.SearchRegions(ctx, req)
+			return err
+		})
+
 		if err != nil {
 			return "", err
 		}
@@ -198,6 +264,17 @@ func (c *Client) SearchRegions(ctx context.Context, options ...SearchRegionOptio
 }
 
 func (c *Client) IntersectRegions(ctx context.Context, r io.Reader, regionType string) ([]*locations.IntersectRegionsResponse_IntersectingRegions, error) {
+	var intersectingRegions []*locations.IntersectRegionsResponse_IntersectingRegions
+	err := c.conn.Do(ctx, func(ctx context.Context) error {
+		var err error
+		intersectingRegions, err = c.intersectRegions(ctx, r, regionType)
+		return err
+	})
+
+	return intersectingRegions, err
+}
+
+func (c *Client) intersectRegions(ctx context.Context, r io.Reader, regionType string) ([]*locations.IntersectRegionsResponse_IntersectingRegions, error) {
 	client, err := c.locationsClient.IntersectRegions(ctx)
 	if err != nil {
 		return nil, err
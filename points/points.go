@@ -13,31 +13,64 @@ import (
 	"github.com/twpayne/go-geom/encoding/geojson"
 	"google.golang.org/api/iterator"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
 
 	"github.com/topos-ai/topos-apis-go/auth"
 	"github.com/topos-ai/topos-apis-go/geometry"
+	"github.com/topos-ai/topos-apis-go/internal/clientconn"
 )
 
 const earthCircomference float64 = 40075017
 
 type Client struct {
 	pointsClient points.PointsClient
-	conn         *grpc.ClientConn
+	conn         *clientconn.Conn
 }
 
-func NewClient(addr string, useLocalCredentials bool) (*Client, error) {
-	conn, err := auth.Dial(addr, useLocalCredentials)
+func NewClient(addr string, useLocalCredentials bool, opts ...auth.DialOption) (*Client, error) {
+	conn, err := auth.Dial(addr, useLocalCredentials, opts...)
 	if err != nil {
 		return nil, err
 	}
 
-	pointsClient := points.NewPointsClient(conn)
-	c := &Client{
-		conn:         conn,
-		pointsClient: pointsClient,
+	return newClient(conn, true), nil
+}
+
+// NewClientFromConn returns a Client using an existing connection, for
+// example one shared with other service clients. Closing the Client does not
+// close conn.
+func NewClientFromConn(conn *grpc.ClientConn) *Client {
+	return newClient(conn, false)
+}
+
+func newClient(conn *grpc.ClientConn, owned bool) *Client {
+	return &Client{
+		conn:         clientconn.New(conn, owned),
+		pointsClient: points.NewPointsClient(conn),
 	}
+}
+
+// State returns the connectivity state of the client's connection.
+func (c *Client) State() connectivity.State {
+	return c.conn.State()
+}
+
+// WaitForReady blocks until the client's connection is ready or ctx is done.
+func (c *Client) WaitForReady(ctx context.Context) error {
+	return c.conn.WaitForReady(ctx)
+}
+
+// Shutdown rejects new calls and waits for in-flight calls, including
+// streams such as PolygonSearchPoints pages, to complete before closing the
+// client. If ctx is done first the remaining calls are canceled.
+func (c *Client) Shutdown(ctx context.Context) error {
+	return c.conn.Shutdown(ctx)
+}
 
-	return c, nil
+// Close cancels in-flight calls and closes the client's connection, unless
+// the client was created with NewClientFromConn.
+func (c *Client) Close() error {
+	return c.conn.Close()
 }
 
 func (c *Client) SetPoint(ctx context.Context, p *points.Point) (*points.Point, error) {
@@ -45,18 +78,32 @@ func (c *Client) SetPoint(ctx context.Context, p *points.Point) (*points.Point,
 		Point: p,
 	}
 
-	return c.pointsClient.SetPoint(ctx, req)
+	var response *points.Point
+	err := c.conn.Do(ctx, func(ctx context.Context) error {
+		var err error
+		response, err = c.pointsClient.SetPoint(ctx, req)
+		return err
+	})
+
+	return response, err
 }
 
 func (c *Client) Brand(ctx context.Context, name string) (*points.Brand, error) {
-	req := &points.GetBrandRequest{
-		Name: name,
-	}
-
-	return c.pointsClient.GetBrand(ctx, req)
+	return c.GetBrand(ctx, name)
 }
 
 func (c *Client) PolygonCountPoints(ctx context.Context, tags []string, polygon *geom.Polygon) (map[string]int64, error) {
+	var tagPoints map[string]int64
+	err := c.conn.Do(ctx, func(ctx context.Context) error {
+		var err error
+		tagPoints, err = c.polygonCountPoints(ctx, tags, polygon)
+		return err
+	})
+
+	return tagPoints, err
+}
+
+func (c *Client) polygonCountPoints(ctx context.Context, tags []string, polygon *geom.Polygon) (map[string]int64, error) {
 	client, err := c.pointsClient.PolygonCountTagPoints(ctx)
 	if err != nil {
 		return nil, err
@@ -115,7 +162,13 @@ func (c *Client) SearchPoints(ctx context.Context, brand string, tags []string,
 			req.PageSize = int32(pageSize)
 		}
 
-		response, err := c.pointsClient.SearchPoints(ctx, req)
+		var response *points.SearchPointsResponse
+		err := c.conn.Do(ctx, func(ctx context.Context) error {
+			var err error
+			response, err = c.pointsClient.SearchPoints(ctx, req)
+			return err
+		})
+
 		if err != nil {
 			return "", err
 		}
@@ -138,53 +191,69 @@ func (c *Client) PolygonSearchPoints(ctx context.Context, brand string, tags []s
 
 	it := &PointIterator{}
 	fetch := func(pageSize int, pageToken string) (string, error) {
-		client, err := c.pointsClient.PolygonSearchPoints(ctx)
-		if err != nil {
-			return "", err
-		}
+		var nextPageToken string
+		err := c.conn.Do(ctx, func(ctx context.Context) error {
+			var err error
+			nextPageToken, err = c.polygonSearchPointsPage(ctx, it, brand, tags, geometryObject, pageSize, pageToken)
+			return err
+		})
+
+		return nextPageToken, err
+	}
 
-		req := &points.PolygonSearchPointsRequest{
-			Brand:            brand,
-			Tags:             tags,
-			GeometryEncoding: geometryproto.Encoding_WKB,
-			PageToken:        pageToken,
-		}
+	it.pageInfo, it.nextFunc = iterator.NewPageInfo(fetch, it.bufLen, it.takeBuf)
+	it.pageInfo.MaxSize = 1024
+	return it, nil
+}
 
-		if pageSize > math.MaxInt32 {
-			req.PageSize = math.MaxInt32
-		} else {
-			req.PageSize = int32(pageSize)
-		}
+func (c *Client) polygonSearchPointsPage(ctx context.Context, it *PointIterator, brand string, tags []string, geometryObject geom.T, pageSize int, pageToken string) (string, error) {
+	client, err := c.pointsClient.PolygonSearchPoints(ctx)
+	if err != nil {
+		return "", err
+	}
 
-		if err := geometry.SendGeometry(geometryproto.Encoding_WKB, geometryObject, func(chunk []byte) error {
-			req.GeometryChunk = chunk
-			if err := client.Send(req); err != nil {
-				return err
-			}
+	req := &points.PolygonSearchPointsRequest{
+		Brand:            brand,
+		Tags:             tags,
+		GeometryEncoding: geometryproto.Encoding_WKB,
+		PageToken:        pageToken,
+	}
 
-			*req = points.PolygonSearchPointsRequest{}
-			return nil
-		}); err != nil {
-			return "", err
-		}
+	if pageSize > math.MaxInt32 {
+		req.PageSize = math.MaxInt32
+	} else {
+		req.PageSize = int32(pageSize)
+	}
 
-		response, err := client.CloseAndRecv()
-		if err != nil {
-			return "", err
-		}
+	encodedGeometry, err := geometry.Marshal(geometryObject, geometryproto.Encoding_WKB)
+	if err != nil {
+		return "", err
+	}
 
-		if it.items == nil {
-			it.items = response.Points
-		} else {
-			it.items = append(it.items, response.Points...)
+	if err := geometry.SendGeometryBytes(encodedGeometry, func(chunk []byte) error {
+		req.GeometryChunk = chunk
+		if err := client.Send(req); err != nil {
+			return err
 		}
 
-		return response.NextPageToken, nil
+		*req = points.PolygonSearchPointsRequest{}
+		return nil
+	}); err != nil {
+		return "", err
 	}
 
-	it.pageInfo, it.nextFunc = iterator.NewPageInfo(fetch, it.bufLen, it.takeBuf)
-	it.pageInfo.MaxSize = 1024
-	return it, nil
+	response, err := client.CloseAndRecv()
+	if err != nil {
+		return "", err
+	}
+
+	if it.items == nil {
+		it.items = response.Points
+	} else {
+		it.items = append(it.items, response.Points...)
+	}
+
+	return response.NextPageToken, nil
 }
 
 func (c *Client) RadiusSearchPoints(ctx context.Context, brands []string, tags []string, latitude, longitude, radius float64) (*PointIterator, error) {
@@ -207,7 +276,13 @@ func (c *Client) RadiusSearchPoints(ctx context.Context, brands []string, tags [
 			req.PageSize = int32(pageSize)
 		}
 
-		response, err := c.pointsClient.RadiusSearchPoints(ctx, req)
+		var response *points.RadiusSearchPointsResponse
+		err := c.conn.Do(ctx, func(ctx context.Context) error {
+			var err error
+			response, err = c.pointsClient.RadiusSearchPoints(ctx, req)
+			return err
+		})
+
 		if err != nil {
 			return "", err
 		}
@@ -231,7 +306,14 @@ func (c *Client) GetBrand(ctx context.Context, brand string) (*points.Brand, err
 		Name: brand,
 	}
 
-	return c.pointsClient.GetBrand(ctx, req)
+	var response *points.Brand
+	err := c.conn.Do(ctx, func(ctx context.Context) error {
+		var err error
+		response, err = c.pointsClient.GetBrand(ctx, req)
+		return err
+	})
+
+	return response, err
 }
 
 type PointIterator struct {
@@ -276,7 +358,13 @@ func (c *Client) CountPoints(ctx context.Context, tags []string, regionName stri
 		Region: regionName,
 	}
 
-	response, err := c.pointsClient.CountTagPoints(ctx, req)
+	var response *points.CountTagPointsResponse
+	err := c.conn.Do(ctx, func(ctx context.Context) error {
+		var err error
+		response, err = c.pointsClient.CountTagPoints(ctx, req)
+		return err
+	})
+
 	if err != nil {
 		return nil, err
 	}
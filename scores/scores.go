@@ -6,26 +6,62 @@ import (
 
 	"github.com/topos-ai/topos-apis/genproto/go/topos/scores/v1"
 	"google.golang.org/api/iterator"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
 
 	"github.com/topos-ai/topos-apis-go/auth"
+	"github.com/topos-ai/topos-apis-go/internal/clientconn"
 )
 
 type Client struct {
 	scoresClient scores.ScoresClient
+	conn         *clientconn.Conn
 }
 
-func NewClient(addr string, useLocalCredentials bool) (*Client, error) {
-	conn, err := auth.Dial(addr, useLocalCredentials)
+func NewClient(addr string, useLocalCredentials bool, opts ...auth.DialOption) (*Client, error) {
+	conn, err := auth.Dial(addr, useLocalCredentials, opts...)
 	if err != nil {
 		return nil, err
 	}
 
-	scoresClient := scores.NewScoresClient(conn)
-	c := &Client{
-		scoresClient: scoresClient,
+	return newClient(conn, true), nil
+}
+
+// NewClientFromConn returns a Client using an existing connection, for
+// example one shared with other service clients. Closing the Client does not
+// close conn.
+func NewClientFromConn(conn *grpc.ClientConn) *Client {
+	return newClient(conn, false)
+}
+
+func newClient(conn *grpc.ClientConn, owned bool) *Client {
+	return &Client{
+		conn:         clientconn.New(conn, owned),
+		scoresClient: scores.NewScoresClient(conn),
 	}
+}
+
+// State returns the connectivity state of the client's connection.
+func (c *Client) State() connectivity.State {
+	return c.conn.State()
+}
 
-	return c, nil
+// WaitForReady blocks until the client's connection is ready or ctx is done.
+func (c *Client) WaitForReady(ctx context.Context) error {
+	return c.conn.WaitForReady(ctx)
+}
+
+// Shutdown rejects new calls and waits for in-flight calls to complete
+// before closing the client. If ctx is done first the remaining calls are
+// canceled.
+func (c *Client) Shutdown(ctx context.Context) error {
+	return c.conn.Shutdown(ctx)
+}
+
+// Close cancels in-flight calls and closes the client's connection, unless
+// the client was created with NewClientFromConn.
+func (c *Client) Close() error {
+	return c.conn.Close()
 }
 
 func (c *Client) SetGraphScore(ctx context.Context, name string, score *scores.Score) error {
@@ -34,8 +70,10 @@ func (c *Client) SetGraphScore(ctx context.Context, name string, score *scores.S
 		Score: score,
 	}
 
-	_, err := c.scoresClient.SetGraphScore(ctx, req)
-	return err
+	return c.conn.Do(ctx, func(ctx context.Context) error {
+		_, err := c.scoresClient.SetGraphScore(ctx, req)
+		return err
+	})
 }
 
 func (c *Client) BatchSetGraphScores(ctx context.Context, name string, batch []*scores.Score) error {
@@ -44,8 +82,10 @@ func (c *Client) BatchSetGraphScores(ctx context.Context, name string, batch []*
 		Scores: batch,
 	}
 
-	_, err := c.scoresClient.BatchSetGraphScores(ctx, req)
-	return err
+	return c.conn.Do(ctx, func(ctx context.Context) error {
+		_, err := c.scoresClient.BatchSetGraphScores(ctx, req)
+		return err
+	})
 }
 
 func (c *Client) TopGraphScores(ctx context.Context, name, vertexA, vertexB string, pageSize int) ([]*scores.Score, error) {
@@ -62,7 +102,13 @@ func (c *Client) TopGraphScores(ctx context.Context, name, vertexA, vertexB stri
 		req.PageSize = int32(pageSize)
 	}
 
-	response, err := c.scoresClient.TopGraphScores(ctx, req)
+	var response *scores.TopGraphScoresResponse
+	err := c.conn.Do(ctx, func(ctx context.Context) error {
+		var err error
+		response, err = c.scoresClient.TopGraphScores(ctx, req)
+		return err
+	})
+
 	if err != nil {
 		return nil, err
 	}
@@ -84,7 +130,13 @@ func (c *Client) ListGraphScores(ctx context.Context, name, vertexA string) (*Sc
 			req.PageSize = int32(pageSize)
 		}
 
-		response, err := c.scoresClient.ListGraphScores(ctx, req)
+		var response *scores.ListGraphScoresResponse
+		err := c.conn.Do(ctx, func(ctx context.Context) error {
+			var err error
+			response, err = c.scoresClient.ListGraphScores(ctx, req)
+			return err
+		})
+
 		if err != nil {
 			return "", err
 		}
@@ -0,0 +1,112 @@
+package topos
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+
+	"github.com/topos-ai/topos-apis-go/auth"
+	"github.com/topos-ai/topos-apis-go/internal/clientconn"
+	"github.com/topos-ai/topos-apis-go/locations"
+	"github.com/topos-ai/topos-apis-go/points"
+	"github.com/topos-ai/topos-apis-go/scores"
+)
+
+// Client accesses the Topos services over a single connection, sharing its
+// credentials and options.
+type Client struct {
+	conn      *clientconn.Conn
+	locations *locations.Client
+	points    *points.Client
+	scores    *scores.Client
+}
+
+func NewClient(addr string, useLocalCredentials bool, opts ...auth.DialOption) (*Client, error) {
+	conn, err := auth.Dial(addr, useLocalCredentials, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	return newClient(conn, true), nil
+}
+
+// NewClientFromConn returns a Client using an existing connection. Closing
+// the Client does not close conn.
+func NewClientFromConn(conn *grpc.ClientConn) *Client {
+	return newClient(conn, false)
+}
+
+func newClient(conn *grpc.ClientConn, owned bool) *Client {
+	return &Client{
+		conn:      clientconn.New(conn, owned),
+		locations: locations.NewClientFromConn(conn),
+		points:    points.NewClientFromConn(conn),
+		scores:    scores.NewClientFromConn(conn),
+	}
+}
+
+func (c *Client) Locations() *locations.Client {
+	return c.locations
+}
+
+func (c *Client) Points() *points.Client {
+	return c.points
+}
+
+func (c *Client) Scores() *scores.Client {
+	return c.scores
+}
+
+// Conn returns the connection shared by the service clients.
+func (c *Client) Conn() *grpc.ClientConn {
+	return c.conn.ClientConn()
+}
+
+// State returns the connectivity state of the shared connection.
+func (c *Client) State() connectivity.State {
+	return c.conn.State()
+}
+
+// WaitForReady blocks until the shared connection is ready or ctx is done.
+func (c *Client) WaitForReady(ctx context.Context) error {
+	return c.conn.WaitForReady(ctx)
+}
+
+// Shutdown rejects new calls and waits for the in-flight calls of every
+// service client to complete before closing the shared connection, if the
+// Client dialed it. If ctx is done first the remaining calls are canceled.
+func (c *Client) Shutdown(ctx context.Context) error {
+	errs := make(chan error, 3)
+	for _, shutdown := range []func(context.Context) error{
+		c.locations.Shutdown,
+		c.points.Shutdown,
+		c.scores.Shutdown,
+	} {
+		go func(shutdown func(context.Context) error) {
+			errs <- shutdown(ctx)
+		}(shutdown)
+	}
+
+	var err error
+	for i := 0; i < cap(errs); i++ {
+		if shutdownErr := <-errs; shutdownErr != nil {
+			err = shutdownErr
+		}
+	}
+
+	if closeErr := c.conn.Close(); err == nil {
+		err = closeErr
+	}
+
+	return err
+}
+
+// Close cancels in-flight calls and closes the shared connection, if the
+// Client dialed it.
+func (c *Client) Close() error {
+	c.locations.Close()
+	c.points.Close()
+	c.scores.Close()
+	return c.conn.Close()
+}